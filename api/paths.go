@@ -19,6 +19,13 @@ const (
 	WhoAreYouPath            = "/who"
 	TablePath                = "/table"
 	ResolvePath              = "/resolve"
+	NeighborsPath            = "/neighbors"
+	NeighborPath             = "/neighbors/%s"
+	MessagesCacheStatsPath   = "/messages/stats"
+	HorizonPath              = "/horizons/%s"
+	QueryPath                = "/query"
+	DHTFindPath              = "/dht/find"
+	DHTStorePath             = "/dht/store"
 )
 
 const (
@@ -28,6 +35,12 @@ const (
 	StatusUp           = "UP"
 )
 
+const (
+	NeighborStatusAlive     = "ALIVE"
+	NeighborStatusSuspected = "SUSPECTED"
+	NeighborStatusDead      = "DEAD"
+)
+
 const (
 	ArchimedesServiceName = "archimedes"
 	Port                  = 50000
@@ -68,3 +81,31 @@ func GetTablePath() string {
 func GetResolvePath() string {
 	return PrefixPath + ResolvePath
 }
+
+func GetNeighborsPath() string {
+	return PrefixPath + NeighborsPath
+}
+
+func GetNeighborPath(neighborId string) string {
+	return PrefixPath + fmt.Sprintf(NeighborPath, neighborId)
+}
+
+func GetMessagesCacheStatsPath() string {
+	return PrefixPath + MessagesCacheStatsPath
+}
+
+func GetHorizonPath(serviceId string) string {
+	return PrefixPath + fmt.Sprintf(HorizonPath, serviceId)
+}
+
+func GetQueryPath() string {
+	return PrefixPath + QueryPath
+}
+
+func GetDHTFindPath() string {
+	return PrefixPath + DHTFindPath
+}
+
+func GetDHTStorePath() string {
+	return PrefixPath + DHTStorePath
+}
@@ -3,4 +3,22 @@ package api
 type ResolvedDTO struct {
 	Host string
 	Port string
+	// Hops is how far the node hosting the service is from the node that resolved it. Services found in the
+	// DHT are asked to their host directly, so they are 1 hop away however far the host is in the network.
+	Hops int
+}
+
+type MessagesCacheStatsDTO struct {
+	Size        int
+	Capacity    int
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+}
+
+type DHTFindReplyDTO struct {
+	Contacts []*DHTContactDTO
+	// Providers is only set if the node has providers stored under the key
+	Providers []*DHTContactDTO
 }
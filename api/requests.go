@@ -7,6 +7,16 @@ import (
 
 type ServiceDTO struct {
 	Ports nat.PortSet
+	// MaxHops is how far from its host the service is advertised, 0 uses the default
+	MaxHops int
+	// MaxCost, if set, also limits how far the service is advertised by the cost of the links to its host.
+	// Without MaxHops it is what limits the service.
+	MaxCost float64
+}
+
+type HorizonDTO struct {
+	MaxHops int
+	MaxCost float64
 }
 
 type ServicesTableEntryDTO struct {
@@ -15,19 +25,97 @@ type ServicesTableEntryDTO struct {
 	Instances      map[string]*Instance
 	NumberOfHops   int
 	MaxHops        int
-	Version        int
+	Version        Version
+	// Cost is the sum of the costs of the links between the sender and the host
+	Cost    float64
+	MaxCost float64
+	// Path holds the ids of the nodes the entry went through, from its host to the node that sent it
+	Path []string
 }
 type DiscoverMsg struct {
 	MessageId    uuid.UUID
 	Origin       string
 	NeighborSent string
 	Entries      map[string]*ServicesTableEntryDTO
+	// Withdrawn holds the services the sender can no longer reach
+	Withdrawn []string
+	// Tombstones holds the services deleted by their hosts, keyed by service id
+	Tombstones map[string]*TombstoneDTO
+	// KeyId identifies the shared key used to compute Signature
+	KeyId     string
+	Signature []byte
 }
 
-type NeighborDTO struct {
+// QueryMsg asks the nodes around for a service the origin does not know, e.g. because it is beyond the
+// horizon of the service
+type QueryMsg struct {
+	QueryId      uuid.UUID
+	Origin       string
+	NeighborSent string
+	ServiceId    string
+	// TTL is how many hops away from the node that receives it the query can still go
+	TTL int
+	// KeyId identifies the shared key used to compute Signature
+	KeyId     string
+	Signature []byte
+}
+
+// DHTContactDTO is a node of the DHT overlay
+type DHTContactDTO struct {
+	Id   string
 	Addr string
 }
 
+// DHTFindMsg asks for the contacts closest to Key and, if FindValue is set, for the providers stored under it
+type DHTFindMsg struct {
+	Sender    DHTContactDTO
+	Key       []byte
+	FindValue bool
+	// KeyId identifies the shared key used to compute Signature
+	KeyId     string
+	Signature []byte
+}
+
+// DHTStoreMsg announces the sender as a provider of the service, stored under the key of the service id
+type DHTStoreMsg struct {
+	Sender    DHTContactDTO
+	ServiceId string
+	// KeyId identifies the shared key used to compute Signature
+	KeyId     string
+	Signature []byte
+}
+
+// TombstoneDTO marks that every entry of Host for the service with a version older than Version was deleted
+type TombstoneDTO struct {
+	Host         string
+	Version      Version
+	NumberOfHops int
+	MaxHops      int
+}
+
+type DigestEntryDTO struct {
+	Host    string
+	Version Version
+}
+
+// TableDigestDTO summarizes a services table, keyed by service id
+type TableDigestDTO struct {
+	Sender  string
+	Entries map[string]*DigestEntryDTO
+	// KeyId identifies the shared key used to compute Signature
+	KeyId     string
+	Signature []byte
+}
+
+type NeighborDTO struct {
+	Id     string
+	Addr   string
+	Status string
+	// Cost is the cost of the link to the neighbor. When adding a neighbor, 0 uses the measured or default
+	// cost.
+	Cost float64
+}
+
 type ToResolveDTO struct {
 	Host string
 	Port nat.Port
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// NewTLSConfig loads the certificate used by an archimedes node or client and the CA that signs the
+// certificates of the others. The same config is used to serve, requiring client certificates, and to
+// connect.
+func NewTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New(fmt.Sprintf("no certificates found in %s", caFile))
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caPool,
+		ClientCAs:    caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func NewTLSClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
+
+// IdFromCertificate returns the archimedes id a certificate was issued to
+func IdFromCertificate(cert *x509.Certificate) string {
+	return cert.Subject.CommonName
+}
+
+func usesTLS(httpClient *http.Client) bool {
+	transport, ok := httpClient.Transport.(*http.Transport)
+	return ok && transport.TLSClientConfig != nil
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/docker/go-connections/nat"
 )
 
@@ -21,6 +24,52 @@ func (s *Service) ToTransfarable() *Service {
 	}
 }
 
+// Version is a logical clock for the changes Origin makes to a service. Incarnation changes every time the
+// origin restarts, so its versions keep increasing even though Seq starts over.
+type Version struct {
+	Origin      string
+	Incarnation int64
+	Seq         int
+}
+
+func NewVersion(origin string, incarnation int64) Version {
+	return Version{
+		Origin:      origin,
+		Incarnation: incarnation,
+		Seq:         0,
+	}
+}
+
+func (v Version) Next() Version {
+	return Version{
+		Origin:      v.Origin,
+		Incarnation: v.Incarnation,
+		Seq:         v.Seq + 1,
+	}
+}
+
+// Compare returns a negative number if v is older than other, zero if they are the same and a positive
+// number if v is newer. Versions of different origins are ordered the same way, with the origin breaking
+// ties, so every node resolves concurrent origins to the same version.
+func (v Version) Compare(other Version) int {
+	switch {
+	case v.Incarnation < other.Incarnation:
+		return -1
+	case v.Incarnation > other.Incarnation:
+		return 1
+	case v.Seq < other.Seq:
+		return -1
+	case v.Seq > other.Seq:
+		return 1
+	default:
+		return strings.Compare(v.Origin, other.Origin)
+	}
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%s/%d/%d", v.Origin, v.Incarnation, v.Seq)
+}
+
 type Instance struct {
 	Id              string
 	ServiceId       string
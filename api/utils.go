@@ -30,6 +30,9 @@ func ResolveServiceInArchimedes(httpClient *http.Client, hostPort string) (strin
 		Port: nat.Port(port + "/tcp"),
 	}
 	archReq := http_utils.BuildRequest(http.MethodPost, DefaultHostPort, GetResolvePath(), toResolve)
+	if usesTLS(httpClient) {
+		archReq.URL.Scheme = "https"
+	}
 
 	resolved := ResolvedDTO{}
 	status, _ := http_utils.DoRequest(httpClient, archReq, &resolved)
@@ -6,6 +6,7 @@ require (
 	github.com/bruno-anjos/scheduler v0.0.1
 	github.com/bruno-anjos/solution-utils v0.0.1
 	github.com/docker/go-connections v0.4.0
+	github.com/fxamacker/cbor/v2 v2.4.0
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/mux v1.7.4
 	github.com/sirupsen/logrus v1.6.0
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"crypto/tls"
+	"flag"
+	"net/http"
+	"strconv"
+
 	"github.com/bruno-anjos/archimedes/api"
+	"github.com/bruno-anjos/archimedes/node"
 	utils "github.com/bruno-anjos/solution-utils"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -10,5 +17,39 @@ const (
 )
 
 func main() {
-	utils.StartServer(serviceName, api.DefaultHostPort, api.Port, api.PrefixPath, routes)
+	config := node.ConfigFromEnv()
+
+	n, err := node.New(node.WithConfig(config))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n.Start()
+
+	if config.TLSConfig != nil {
+		startTLSServer(n, config.TLSConfig)
+		return
+	}
+
+	utils.StartServer(serviceName, api.DefaultHostPort, api.Port, api.PrefixPath, n.Routes())
+}
+
+// startTLSServer serves the node the same way utils.StartServer does, but over TLS with client certificates
+func startTLSServer(n *node.Node, tlsConfig *tls.Config) {
+	debug := flag.Bool("d", false, "add debug logs")
+	flag.Parse()
+
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	server := &http.Server{
+		Addr:      ":" + strconv.Itoa(api.Port),
+		Handler:   n.Handler(),
+		TLSConfig: tlsConfig,
+	}
+
+	log.Infof("Starting %s server with TLS on port %d", serviceName, api.Port)
+
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }
@@ -0,0 +1,91 @@
+package node
+
+import (
+	"net"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	genericutils "github.com/bruno-anjos/solution-utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAntiEntropyInterval = 30 * time.Second
+)
+
+// antiEntropy periodically pulls from a random neighbor the entries that differ from ours, which repairs
+// the updates lost by the push based dissemination (e.g. while partitioned)
+func (n *Node) antiEntropy() {
+	ticker := time.NewTicker(n.config.AntiEntropyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, neighbor := range pickRandomNeighbors(n.neighborsTable.GetAvailableNeighbors(), 1) {
+			n.syncWithNeighbor(neighbor)
+		}
+	}
+}
+
+func (n *Node) syncWithNeighbor(neighbor *genericutils.Node) {
+	digest, err := n.signer.SignDigest(&api.TableDigestDTO{
+		Sender:  n.id,
+		Entries: n.servicesTable.ToDigest(),
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	discoverMsg, err := n.transport.SyncTable(neighbor.Addr, digest)
+	if err != nil {
+		log.Warnf("failed syncing table with %s: %s", neighbor.Id, err)
+		return
+	}
+
+	err = n.signer.Verify(discoverMsg)
+	if err != nil {
+		log.Warnf("rejecting table from %s: %s", neighbor.Id, err)
+		return
+	}
+
+	if len(discoverMsg.Entries) == 0 && len(discoverMsg.Tombstones) == 0 && len(discoverMsg.Withdrawn) == 0 {
+		log.Debugf("table in sync with %s", neighbor.Id)
+		return
+	}
+
+	log.Debugf("got %d differing entries and %d tombstones from %s", len(discoverMsg.Entries),
+		len(discoverMsg.Tombstones), neighbor.Id)
+
+	applied := n.servicesTable.ApplyTombstones(discoverMsg.Tombstones)
+	if len(applied) > 0 {
+		n.sendTombstones(applied)
+	}
+
+	remoteAddr, _, err := net.SplitHostPort(neighbor.Addr)
+	if err != nil {
+		remoteAddr = neighbor.Addr
+	}
+
+	dropLoopedEntries(n.id, discoverMsg)
+	n.preprocessMessage(remoteAddr, discoverMsg)
+	n.applyLinkCost(discoverMsg)
+
+	changed, beyondHorizon := n.servicesTable.UpdateTableWithDiscoverMessage(discoverMsg.NeighborSent,
+		discoverMsg)
+	if changed {
+		n.tableBroadcaster.Notify()
+	}
+
+	// with poisoned reverse the neighbor withdraws the services it learned from us
+	withdrawn := n.servicesTable.WithdrawNeighborServices(discoverMsg.NeighborSent, discoverMsg.Withdrawn)
+	withdrawn = append(withdrawn, beyondHorizon...)
+	if len(withdrawn) > 0 {
+		n.sendWithdrawn(withdrawn)
+	}
+}
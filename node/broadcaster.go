@@ -0,0 +1,65 @@
+package node
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBroadcastWindow = 100 * time.Millisecond
+)
+
+// TableBroadcaster sends the services table to the neighbors in batches. Changes notified within window
+// of the first change of a batch are sent together, in a single message per neighbor, so registering many
+// services or instances at once does not flood the neighbors, and the handlers do not wait on gossip.
+type TableBroadcaster struct {
+	window time.Duration
+	// send sends a batch
+	send    func()
+	changed chan struct{}
+}
+
+func NewTableBroadcaster(window time.Duration, send func()) *TableBroadcaster {
+	return &TableBroadcaster{
+		window: window,
+		send:   send,
+		// a single pending notification is enough since every batch sends all the changes so far
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Notify tells the broadcaster the table changed. It never blocks.
+func (b *TableBroadcaster) Notify() {
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run blocks sending a batch for every window with changes, until stop is closed
+func (b *TableBroadcaster) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-b.changed:
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(b.window):
+		}
+
+		// the changes notified while waiting are already part of this batch
+		select {
+		case <-b.changed:
+		default:
+		}
+
+		start := time.Now()
+		b.send()
+		log.Debugf("broadcast table batch in %s", time.Since(start))
+	}
+}
@@ -0,0 +1,295 @@
+package node
+
+import (
+	"crypto/tls"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Environment variables used to configure archimedes
+const (
+	heartbeatIntervalEnvVar = "ARCHIMEDES_HEARTBEAT_INTERVAL"
+	suspicionTimeoutEnvVar  = "ARCHIMEDES_SUSPICION_TIMEOUT"
+	failureTimeoutEnvVar    = "ARCHIMEDES_FAILURE_TIMEOUT"
+	messagesCacheTTLEnvVar  = "ARCHIMEDES_MESSAGES_CACHE_TTL"
+	messagesCacheCapEnvVar  = "ARCHIMEDES_MESSAGES_CACHE_CAPACITY"
+
+	antiEntropyIntervalEnvVar  = "ARCHIMEDES_ANTI_ENTROPY_INTERVAL"
+	tombstoneGracePeriodEnvVar = "ARCHIMEDES_TOMBSTONE_GRACE_PERIOD"
+
+	disseminationEnvVar       = "ARCHIMEDES_DISSEMINATION"
+	gossipFanoutEnvVar        = "ARCHIMEDES_GOSSIP_FANOUT"
+	gossipIntervalEnvVar      = "ARCHIMEDES_GOSSIP_INTERVAL"
+	gossipStopThresholdEnvVar = "ARCHIMEDES_GOSSIP_STOP_THRESHOLD"
+
+	hmacKeysEnvVar        = "ARCHIMEDES_HMAC_KEYS"
+	hmacActiveKeyIdEnvVar = "ARCHIMEDES_HMAC_ACTIVE_KEY_ID"
+
+	tlsCertEnvVar = "ARCHIMEDES_TLS_CERT"
+	tlsKeyEnvVar  = "ARCHIMEDES_TLS_KEY"
+	tlsCAEnvVar   = "ARCHIMEDES_TLS_CA"
+
+	encodingEnvVar    = "ARCHIMEDES_ENCODING"
+	compressionEnvVar = "ARCHIMEDES_COMPRESSION"
+
+	broadcastWindowEnvVar = "ARCHIMEDES_BROADCAST_WINDOW"
+
+	refreshIntervalEnvVar = "ARCHIMEDES_REFRESH_INTERVAL"
+	entryTTLEnvVar        = "ARCHIMEDES_ENTRY_TTL"
+
+	poisonedReverseEnvVar = "ARCHIMEDES_POISONED_REVERSE"
+
+	linkCostEnvVar = "ARCHIMEDES_LINK_COST"
+
+	queryTTLEnvVar       = "ARCHIMEDES_QUERY_TTL"
+	queryCacheTTLEnvVar  = "ARCHIMEDES_QUERY_CACHE_TTL"
+	resolveTimeoutEnvVar = "ARCHIMEDES_RESOLVE_TIMEOUT"
+
+	dhtEnvVar           = "ARCHIMEDES_DHT"
+	dhtBucketSizeEnvVar = "ARCHIMEDES_DHT_BUCKET_SIZE"
+)
+
+type (
+	// Config holds what can be tuned in an archimedes node. Zero values are replaced by the defaults.
+	Config struct {
+		HeartbeatInterval time.Duration
+		SuspicionTimeout  time.Duration
+		FailureTimeout    time.Duration
+
+		MessagesCacheTTL      time.Duration
+		MessagesCacheCapacity int
+
+		AntiEntropyInterval  time.Duration
+		TombstoneGracePeriod time.Duration
+		RefreshInterval      time.Duration
+		EntryTTL             time.Duration
+		BroadcastWindow      time.Duration
+		// PoisonedReverse makes the node tell each neighbor it cannot reach the services learned from it,
+		// instead of just not advertising them back
+		PoisonedReverse bool
+		// LinkCost is either hop, every link costing the same, or rtt, the links costing their RTT. The
+		// costs given when adding neighbors take precedence.
+		LinkCost string
+
+		// QueryTTL is the largest radius, in hops, of the search for a service missing from the table when
+		// resolving it
+		QueryTTL int
+		// QueryCacheTTL is how long the answers to those queries are kept
+		QueryCacheTTL time.Duration
+		// ResolveTimeout bounds the whole search for a service missing from the table, which has to end
+		// before the clients resolving it give up
+		ResolveTimeout time.Duration
+
+		// DHT enables the DHT the services missing from the table are looked up in when everything else fails
+		DHT           bool
+		DHTBucketSize int
+
+		// Dissemination is either flood or epidemic
+		Dissemination       string
+		GossipFanout        int
+		GossipInterval      time.Duration
+		GossipStopThreshold int
+
+		// TLSConfig is nil when using plain HTTP
+		TLSConfig *tls.Config
+		// Encoding is either json or cbor and Compression either none or gzip
+		Encoding    string
+		Compression string
+		// HMACKeys are the keys discover messages are signed with, by key id. Messages are not signed if
+		// there are none.
+		HMACKeys        map[string][]byte
+		HMACActiveKeyId string
+	}
+)
+
+func DefaultConfig() *Config {
+	return &Config{
+		HeartbeatInterval:     defaultHeartbeatInterval,
+		SuspicionTimeout:      defaultSuspicionTimeout,
+		FailureTimeout:        defaultFailureTimeout,
+		MessagesCacheTTL:      defaultMessagesCacheTTL,
+		MessagesCacheCapacity: defaultMessagesCacheCapacity,
+		AntiEntropyInterval:   defaultAntiEntropyInterval,
+		TombstoneGracePeriod:  defaultTombstoneGracePeriod,
+		RefreshInterval:       defaultRefreshInterval,
+		EntryTTL:              defaultEntryTTL,
+		BroadcastWindow:       defaultBroadcastWindow,
+		PoisonedReverse:       false,
+		LinkCost:              hopLinkCostName,
+		QueryTTL:              defaultQueryTTL,
+		QueryCacheTTL:         defaultQueryCacheTTL,
+		ResolveTimeout:        defaultResolveTimeout,
+		DHT:                   false,
+		DHTBucketSize:         defaultDHTBucketSize,
+		Dissemination:         floodDisseminationName,
+		GossipFanout:          defaultGossipFanout,
+		GossipInterval:        defaultGossipInterval,
+		GossipStopThreshold:   defaultGossipStopThreshold,
+		TLSConfig:             nil,
+		Encoding:              jsonEncodingName,
+		Compression:           noCompressionName,
+		HMACKeys:              map[string][]byte{},
+		HMACActiveKeyId:       "",
+	}
+}
+
+// ConfigFromEnv reads the config from the ARCHIMEDES_* environment variables, using the defaults for the
+// ones not set
+func ConfigFromEnv() *Config {
+	dissemination, ok := os.LookupEnv(disseminationEnvVar)
+	if !ok {
+		dissemination = floodDisseminationName
+	}
+
+	encoding, ok := os.LookupEnv(encodingEnvVar)
+	if !ok {
+		encoding = jsonEncodingName
+	}
+
+	compression, ok := os.LookupEnv(compressionEnvVar)
+	if !ok {
+		compression = noCompressionName
+	}
+
+	linkCost, ok := os.LookupEnv(linkCostEnvVar)
+	if !ok {
+		linkCost = hopLinkCostName
+	}
+
+	hmacKeys, hmacActiveKeyId := hmacKeysFromEnv()
+
+	return &Config{
+		HeartbeatInterval:     getDurationFromEnv(heartbeatIntervalEnvVar, defaultHeartbeatInterval),
+		SuspicionTimeout:      getDurationFromEnv(suspicionTimeoutEnvVar, defaultSuspicionTimeout),
+		FailureTimeout:        getDurationFromEnv(failureTimeoutEnvVar, defaultFailureTimeout),
+		MessagesCacheTTL:      getDurationFromEnv(messagesCacheTTLEnvVar, defaultMessagesCacheTTL),
+		MessagesCacheCapacity: getIntFromEnv(messagesCacheCapEnvVar, defaultMessagesCacheCapacity),
+		AntiEntropyInterval:   getDurationFromEnv(antiEntropyIntervalEnvVar, defaultAntiEntropyInterval),
+		TombstoneGracePeriod:  getDurationFromEnv(tombstoneGracePeriodEnvVar, defaultTombstoneGracePeriod),
+		RefreshInterval:       getDurationFromEnv(refreshIntervalEnvVar, defaultRefreshInterval),
+		EntryTTL:              getDurationFromEnv(entryTTLEnvVar, defaultEntryTTL),
+		BroadcastWindow:       getDurationFromEnv(broadcastWindowEnvVar, defaultBroadcastWindow),
+		PoisonedReverse:       getBoolFromEnv(poisonedReverseEnvVar, false),
+		LinkCost:              linkCost,
+		QueryTTL:              getIntFromEnv(queryTTLEnvVar, defaultQueryTTL),
+		QueryCacheTTL:         getDurationFromEnv(queryCacheTTLEnvVar, defaultQueryCacheTTL),
+		ResolveTimeout:        getDurationFromEnv(resolveTimeoutEnvVar, defaultResolveTimeout),
+		DHT:                   getBoolFromEnv(dhtEnvVar, false),
+		DHTBucketSize:         getIntFromEnv(dhtBucketSizeEnvVar, defaultDHTBucketSize),
+		Dissemination:         dissemination,
+		GossipFanout:          getIntFromEnv(gossipFanoutEnvVar, defaultGossipFanout),
+		GossipInterval:        getDurationFromEnv(gossipIntervalEnvVar, defaultGossipInterval),
+		GossipStopThreshold:   getIntFromEnv(gossipStopThresholdEnvVar, defaultGossipStopThreshold),
+		TLSConfig:             loadTLSConfigFromEnv(),
+		Encoding:              encoding,
+		Compression:           compression,
+		HMACKeys:              hmacKeys,
+		HMACActiveKeyId:       hmacActiveKeyId,
+	}
+}
+
+// withDefaults returns a copy of the config where the zero values are replaced by the defaults
+func (c *Config) withDefaults() *Config {
+	defaults := DefaultConfig()
+	config := *c
+
+	for _, duration := range []struct {
+		value        *time.Duration
+		defaultValue time.Duration
+	}{
+		{&config.HeartbeatInterval, defaults.HeartbeatInterval},
+		{&config.SuspicionTimeout, defaults.SuspicionTimeout},
+		{&config.FailureTimeout, defaults.FailureTimeout},
+		{&config.MessagesCacheTTL, defaults.MessagesCacheTTL},
+		{&config.AntiEntropyInterval, defaults.AntiEntropyInterval},
+		{&config.TombstoneGracePeriod, defaults.TombstoneGracePeriod},
+		{&config.RefreshInterval, defaults.RefreshInterval},
+		{&config.EntryTTL, defaults.EntryTTL},
+		{&config.BroadcastWindow, defaults.BroadcastWindow},
+		{&config.GossipInterval, defaults.GossipInterval},
+		{&config.QueryCacheTTL, defaults.QueryCacheTTL},
+		{&config.ResolveTimeout, defaults.ResolveTimeout},
+	} {
+		if *duration.value <= 0 {
+			*duration.value = duration.defaultValue
+		}
+	}
+
+	if config.MessagesCacheCapacity <= 0 {
+		config.MessagesCacheCapacity = defaults.MessagesCacheCapacity
+	}
+	if config.GossipFanout <= 0 {
+		config.GossipFanout = defaults.GossipFanout
+	}
+	if config.GossipStopThreshold <= 0 {
+		config.GossipStopThreshold = defaults.GossipStopThreshold
+	}
+	if config.QueryTTL <= 0 {
+		config.QueryTTL = defaults.QueryTTL
+	}
+	if config.DHTBucketSize <= 0 {
+		config.DHTBucketSize = defaults.DHTBucketSize
+	}
+	if config.Dissemination == "" {
+		config.Dissemination = defaults.Dissemination
+	}
+	if config.Encoding == "" {
+		config.Encoding = defaults.Encoding
+	}
+	if config.Compression == "" {
+		config.Compression = defaults.Compression
+	}
+	if config.LinkCost == "" {
+		config.LinkCost = defaults.LinkCost
+	}
+
+	return &config
+}
+
+func getDurationFromEnv(envVar string, defaultValue time.Duration) time.Duration {
+	value, ok := os.LookupEnv(envVar)
+	if !ok {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Errorf("invalid value %s for %s, using default %s", value, envVar, defaultValue)
+		return defaultValue
+	}
+
+	return duration
+}
+
+func getIntFromEnv(envVar string, defaultValue int) int {
+	value, ok := os.LookupEnv(envVar)
+	if !ok {
+		return defaultValue
+	}
+
+	intValue, err := strconv.Atoi(value)
+	if err != nil || intValue <= 0 {
+		log.Errorf("invalid value %s for %s, using default %d", value, envVar, defaultValue)
+		return defaultValue
+	}
+
+	return intValue
+}
+
+func getBoolFromEnv(envVar string, defaultValue bool) bool {
+	value, ok := os.LookupEnv(envVar)
+	if !ok {
+		return defaultValue
+	}
+
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Errorf("invalid value %s for %s, using default %t", value, envVar, defaultValue)
+		return defaultValue
+	}
+
+	return boolValue
+}
@@ -0,0 +1,467 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	genericutils "github.com/bruno-anjos/solution-utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	dhtKeyBits = sha1.Size * 8
+
+	defaultDHTBucketSize = 8
+	// dhtAlpha is how many nodes a lookup queries at once
+	dhtAlpha = 3
+)
+
+type (
+	dhtKey [sha1.Size]byte
+
+	dhtRecord struct {
+		Provider *genericutils.Node
+		StoredAt time.Time
+	}
+
+	dhtFindResult struct {
+		contact *genericutils.Node
+		reply   *api.DHTFindReplyDTO
+		err     error
+	}
+
+	// DHT is a Kademlia overlay over the archimedes nodes that maps the id of each service to the nodes hosting
+	// it, so services beyond every horizon can still be found without flooding. The key of a node is the
+	// SHA-1 of its id and the key of a service the SHA-1 of the service id. The hosts announce their services
+	// to the nodes closest to their keys, as soft state that has to be refreshed.
+	DHT struct {
+		node       *Node
+		self       dhtKey
+		bucketSize int
+
+		bucketsLock sync.Mutex
+		// buckets holds, by the number of leading bits shared with this node key, the contacts least
+		// recently seen first
+		buckets [dhtKeyBits][]*genericutils.Node
+
+		// recordsMap holds the providers stored in this node, by key and then by provider id
+		recordsMap sync.Map
+	}
+
+	typeDHTRecordsMapKey   = string
+	typeDHTRecordsMapValue = *sync.Map
+
+	typeDHTProvidersMapKey   = string
+	typeDHTProvidersMapValue = *dhtRecord
+)
+
+func newDHTKey(id string) dhtKey {
+	return sha1.Sum([]byte(id))
+}
+
+func (k dhtKey) distance(other dhtKey) (distance dhtKey) {
+	for i := range k {
+		distance[i] = k[i] ^ other[i]
+	}
+
+	return
+}
+
+// commonPrefixLen returns the number of leading bits the keys share, which is the bucket other belongs to
+func (k dhtKey) commonPrefixLen(other dhtKey) int {
+	distance := k.distance(other)
+	for i, b := range distance {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+
+	return dhtKeyBits
+}
+
+func (k dhtKey) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+func newDHT(n *Node) *DHT {
+	return &DHT{
+		node:        n,
+		self:        newDHTKey(n.id),
+		bucketSize:  n.config.DHTBucketSize,
+		bucketsLock: sync.Mutex{},
+		recordsMap:  sync.Map{},
+	}
+}
+
+// Run keeps the routing table and the records fresh until stop is closed: it looks up this node key, which
+// fills the buckets, announces the local services again and expires the records not refreshed
+func (d *DHT) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.node.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		d.Bootstrap()
+
+		for _, serviceId := range d.node.servicesTable.GetLocalServices() {
+			d.Publish(serviceId)
+		}
+
+		d.expireRecords(d.node.config.EntryTTL)
+	}
+}
+
+// Bootstrap looks up this node key, which fills the routing table with the contacts around it
+func (d *DHT) Bootstrap() {
+	closest, _ := d.lookup(context.Background(), d.self, false)
+	log.Debugf("dht bootstrapped with %d close contacts", len(closest))
+}
+
+// AddContact records that the contact was seen. If its bucket is full the least recently seen contact is
+// pinged and only replaced if it does not answer, since contacts that have been up longer are more likely
+// to stay up.
+func (d *DHT) AddContact(contact *genericutils.Node) {
+	if contact.Id == d.node.id {
+		return
+	}
+
+	index := d.bucketIndex(contact.Id)
+
+	d.bucketsLock.Lock()
+	bucket := d.buckets[index]
+	for i, existing := range bucket {
+		if existing.Id == contact.Id {
+			d.buckets[index] = append(append(bucket[:i:i], bucket[i+1:]...), contact)
+			d.bucketsLock.Unlock()
+			return
+		}
+	}
+
+	if len(bucket) < d.bucketSize {
+		d.buckets[index] = append(bucket, contact)
+		d.bucketsLock.Unlock()
+		return
+	}
+
+	leastRecent := bucket[0]
+	d.bucketsLock.Unlock()
+
+	_, err := d.node.transport.WhoAreYou(leastRecent.Addr)
+	if err == nil {
+		d.AddContact(leastRecent)
+		return
+	}
+
+	log.Debugf("replacing unresponsive dht contact %s with %s", leastRecent.Id, contact.Id)
+
+	d.RemoveContact(leastRecent.Id)
+	d.AddContact(contact)
+}
+
+func (d *DHT) RemoveContact(contactId string) {
+	index := d.bucketIndex(contactId)
+
+	d.bucketsLock.Lock()
+	defer d.bucketsLock.Unlock()
+
+	bucket := d.buckets[index]
+	for i, existing := range bucket {
+		if existing.Id == contactId {
+			d.buckets[index] = append(bucket[:i:i], bucket[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *DHT) bucketIndex(contactId string) int {
+	index := d.self.commonPrefixLen(newDHTKey(contactId))
+	if index == dhtKeyBits {
+		// only a node with the same id would share every bit
+		index = dhtKeyBits - 1
+	}
+
+	return index
+}
+
+// closestContacts returns up to count contacts of the routing table, closest to key first
+func (d *DHT) closestContacts(key dhtKey, count int) []*genericutils.Node {
+	var contacts []*genericutils.Node
+
+	d.bucketsLock.Lock()
+	for _, bucket := range d.buckets {
+		contacts = append(contacts, bucket...)
+	}
+	d.bucketsLock.Unlock()
+
+	sortByDistance(key, contacts)
+
+	if len(contacts) > count {
+		contacts = contacts[:count]
+	}
+
+	return contacts
+}
+
+func sortByDistance(key dhtKey, contacts []*genericutils.Node) {
+	sort.Slice(contacts, func(i, j int) bool {
+		distanceI := key.distance(newDHTKey(contacts[i].Id))
+		distanceJ := key.distance(newDHTKey(contacts[j].Id))
+		return bytes.Compare(distanceI[:], distanceJ[:]) < 0
+	})
+}
+
+// Publish announces this node as a provider of the service to the nodes closest to the service key
+func (d *DHT) Publish(serviceId string) {
+	key := newDHTKey(serviceId)
+	closest, _ := d.lookup(context.Background(), key, false)
+
+	storeMsg, err := d.node.signer.SignDHTStore(&api.DHTStoreMsg{
+		Sender:    d.contactDTO(),
+		ServiceId: serviceId,
+		KeyId:     "",
+		Signature: nil,
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, contact := range closest {
+		err = d.node.transport.DHTStore(contact.Addr, storeMsg)
+		if err != nil {
+			log.Warnf("failed storing service %s in %s: %s", serviceId, contact.Id, err)
+		}
+	}
+
+	log.Debugf("published service %s to %d dht nodes", serviceId, len(closest))
+}
+
+// FindProviders returns the nodes that announced they host the service, or the ones found until ctx is done
+func (d *DHT) FindProviders(ctx context.Context, serviceId string) []*genericutils.Node {
+	key := newDHTKey(serviceId)
+
+	providers := d.getProviders(key)
+	if len(providers) > 0 {
+		return providers
+	}
+
+	_, providerDTOs := d.lookup(ctx, key, true)
+	for _, providerDTO := range providerDTOs {
+		providers = append(providers, genericutils.NewNode(providerDTO.Id, providerDTO.Addr))
+	}
+
+	return providers
+}
+
+// lookup iteratively queries the nodes closest to key, dhtAlpha at a time, getting closer ones from their
+// answers until the closest ones known have all been queried. It returns them, or, if findValue is set,
+// stops at the first node that has providers stored under key and returns those.
+func (d *DHT) lookup(ctx context.Context, key dhtKey, findValue bool) (closest []*genericutils.Node,
+	providers []*api.DHTContactDTO) {
+	findMsg, err := d.node.signer.SignDHTFind(&api.DHTFindMsg{
+		Sender:    d.contactDTO(),
+		Key:       key[:],
+		FindValue: findValue,
+		KeyId:     "",
+		Signature: nil,
+	})
+	if err != nil {
+		log.Error(err)
+		return nil, nil
+	}
+
+	shortlist := d.closestContacts(key, d.bucketSize)
+	seen := map[string]struct{}{}
+	for _, contact := range shortlist {
+		seen[contact.Id] = struct{}{}
+	}
+	queried := map[string]struct{}{}
+
+	for {
+		var toQuery []*genericutils.Node
+		for _, contact := range shortlist {
+			if len(toQuery) == dhtAlpha {
+				break
+			}
+
+			if _, ok := queried[contact.Id]; !ok {
+				queried[contact.Id] = struct{}{}
+				toQuery = append(toQuery, contact)
+			}
+		}
+
+		if len(toQuery) == 0 {
+			return shortlist, nil
+		}
+
+		results := make(chan dhtFindResult, len(toQuery))
+		for _, contact := range toQuery {
+			go func(contact *genericutils.Node) {
+				reply, err := d.node.transport.DHTFind(ctx, contact.Addr, findMsg)
+				results <- dhtFindResult{
+					contact: contact,
+					reply:   reply,
+					err:     err,
+				}
+			}(contact)
+		}
+
+		failed := map[string]struct{}{}
+		for range toQuery {
+			result := <-results
+			if ctx.Err() != nil {
+				// the contacts did not fail, the lookup was given up on
+				return shortlist, nil
+			}
+
+			if result.err != nil {
+				log.Debugf("dht lookup in %s failed: %s", result.contact.Id, result.err)
+				failed[result.contact.Id] = struct{}{}
+				d.RemoveContact(result.contact.Id)
+				continue
+			}
+
+			go d.AddContact(result.contact)
+
+			if findValue && len(result.reply.Providers) > 0 {
+				return nil, result.reply.Providers
+			}
+
+			for _, contactDTO := range result.reply.Contacts {
+				if _, ok := seen[contactDTO.Id]; ok || contactDTO.Id == d.node.id {
+					continue
+				}
+
+				seen[contactDTO.Id] = struct{}{}
+				shortlist = append(shortlist, genericutils.NewNode(contactDTO.Id, contactDTO.Addr))
+			}
+		}
+
+		responsive := shortlist[:0]
+		for _, contact := range shortlist {
+			if _, ok := failed[contact.Id]; !ok {
+				responsive = append(responsive, contact)
+			}
+		}
+		shortlist = responsive
+
+		sortByDistance(key, shortlist)
+		if len(shortlist) > d.bucketSize {
+			shortlist = shortlist[:d.bucketSize]
+		}
+	}
+}
+
+// HandleFind answers a lookup with the contacts closest to the key and the providers stored under it
+func (d *DHT) HandleFind(sender *genericutils.Node, findMsg *api.DHTFindMsg) *api.DHTFindReplyDTO {
+	go d.AddContact(sender)
+
+	var key dhtKey
+	copy(key[:], findMsg.Key)
+
+	reply := &api.DHTFindReplyDTO{
+		Contacts:  []*api.DHTContactDTO{},
+		Providers: []*api.DHTContactDTO{},
+	}
+
+	for _, contact := range d.closestContacts(key, d.bucketSize) {
+		reply.Contacts = append(reply.Contacts, &api.DHTContactDTO{
+			Id:   contact.Id,
+			Addr: contact.Addr,
+		})
+	}
+
+	if findMsg.FindValue {
+		for _, provider := range d.getProviders(key) {
+			reply.Providers = append(reply.Providers, &api.DHTContactDTO{
+				Id:   provider.Id,
+				Addr: provider.Addr,
+			})
+		}
+	}
+
+	return reply
+}
+
+// HandleStore records the sender as a provider of the service. The store message is checked by the handler,
+// and what a provider answers is only taken if it is the host of the service.
+func (d *DHT) HandleStore(sender *genericutils.Node, storeMsg *api.DHTStoreMsg) {
+	go d.AddContact(sender)
+
+	key := newDHTKey(storeMsg.ServiceId)
+
+	value, _ := d.recordsMap.LoadOrStore(key.String(), &sync.Map{})
+	providers := value.(typeDHTRecordsMapValue)
+	providers.Store(sender.Id, &dhtRecord{
+		Provider: sender,
+		StoredAt: time.Now(),
+	})
+
+	log.Debugf("stored %s as provider of service %s", sender.Id, storeMsg.ServiceId)
+}
+
+func (d *DHT) getProviders(key dhtKey) (providers []*genericutils.Node) {
+	value, ok := d.recordsMap.Load(key.String())
+	if !ok {
+		return nil
+	}
+
+	value.(typeDHTRecordsMapValue).Range(func(_, value interface{}) bool {
+		providers = append(providers, value.(typeDHTProvidersMapValue).Provider)
+		return true
+	})
+
+	return providers
+}
+
+// expireRecords deletes the providers that did not announce themselves again within ttl
+func (d *DHT) expireRecords(ttl time.Duration) {
+	d.recordsMap.Range(func(key, value interface{}) bool {
+		providers := value.(typeDHTRecordsMapValue)
+		providers.Range(func(providerId, value interface{}) bool {
+			if time.Since(value.(typeDHTProvidersMapValue).StoredAt) > ttl {
+				log.Debugf("dht record of provider %s under %s expired", providerId, key)
+				providers.Delete(providerId)
+			}
+			return true
+		})
+		return true
+	})
+}
+
+func (d *DHT) contactDTO() api.DHTContactDTO {
+	return api.DHTContactDTO{
+		Id:   d.node.id,
+		Addr: d.node.address,
+	}
+}
+
+// contactFromRequest uses the address the request came from with the port the sender announced, since the
+// address a node announces may only make sense in its own network (e.g. a container name)
+func contactFromRequest(remoteAddr string, sender *api.DHTContactDTO) *genericutils.Node {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return genericutils.NewNode(sender.Id, sender.Addr)
+	}
+
+	_, port, err := net.SplitHostPort(sender.Addr)
+	if err != nil {
+		port = strconv.Itoa(api.Port)
+	}
+
+	return genericutils.NewNode(sender.Id, net.JoinHostPort(host, port))
+}
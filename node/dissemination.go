@@ -0,0 +1,221 @@
+package node
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	genericutils "github.com/bruno-anjos/solution-utils"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Dissemination strategies
+const (
+	floodDisseminationName    = "flood"
+	epidemicDisseminationName = "epidemic"
+)
+
+const (
+	defaultGossipFanout        = 2
+	defaultGossipInterval      = 1 * time.Second
+	defaultGossipStopThreshold = 2
+	maxGossipRounds            = 20
+)
+
+type (
+	// Dissemination decides which neighbors get a message and when
+	Dissemination interface {
+		// Spread sends the message to the neighbors, except the ones in exclude
+		Spread(discoverMsg *api.DiscoverMsg, exclude map[string]struct{})
+		// SendTable sends the entries of the table that changed to the neighbors and waits for them to be sent
+		SendTable()
+		// Run blocks running the background work of the strategy, if any, until stop is closed
+		Run(stop <-chan struct{})
+	}
+
+	// floodDissemination sends every message right away to all the neighbors
+	floodDissemination struct {
+		node *Node
+	}
+
+	rumor struct {
+		discoverMsg *api.DiscoverMsg
+		exclude     map[string]struct{}
+		// unnecessary counts the pushes to neighbors that already knew the rumor
+		unnecessary int
+		rounds      int
+	}
+
+	// epidemicDissemination spreads messages as rumors. Every round it picks fanout random neighbors, pushes
+	// them the active rumors and the entries they were not sent yet, and pulls the entries they have that
+	// differ from ours. A rumor stops being spread after being pushed stopThreshold times to neighbors that
+	// already knew it. Changes to the table are pushed right away to fanout neighbors only, the others get
+	// them in later rounds.
+	epidemicDissemination struct {
+		node          *Node
+		fanout        int
+		interval      time.Duration
+		stopThreshold int
+
+		rumorsLock sync.Mutex
+		rumors     map[uuid.UUID]*rumor
+	}
+)
+
+func newDissemination(n *Node) Dissemination {
+	switch n.config.Dissemination {
+	case epidemicDisseminationName:
+		dissemination := newEpidemicDissemination(n, n.config.GossipFanout, n.config.GossipInterval,
+			n.config.GossipStopThreshold)
+		log.Infof("using %s dissemination with fanout %d every %s", epidemicDisseminationName,
+			dissemination.fanout, dissemination.interval)
+		return dissemination
+	case floodDisseminationName:
+	default:
+		log.Errorf("unknown dissemination %s, using %s", n.config.Dissemination, floodDisseminationName)
+	}
+
+	log.Infof("using %s dissemination", floodDisseminationName)
+
+	return &floodDissemination{
+		node: n,
+	}
+}
+
+func (f *floodDissemination) Spread(discoverMsg *api.DiscoverMsg, exclude map[string]struct{}) {
+	for neighborId, neighbor := range f.node.neighborsTable.GetAvailableNeighbors() {
+		if _, ok := exclude[neighborId]; ok {
+			continue
+		}
+
+		go f.node.sendDiscoverMsgToNeighbor(neighbor, discoverMsg)
+	}
+}
+
+func (f *floodDissemination) SendTable() {
+	var neighbors []*genericutils.Node
+	for _, neighbor := range f.node.neighborsTable.GetAvailableNeighbors() {
+		neighbors = append(neighbors, neighbor)
+	}
+
+	f.node.sendServicesTableToNeighbors(neighbors)
+}
+
+func (f *floodDissemination) Run(_ <-chan struct{}) {}
+
+func newEpidemicDissemination(n *Node, fanout int, interval time.Duration,
+	stopThreshold int) *epidemicDissemination {
+	return &epidemicDissemination{
+		node:          n,
+		fanout:        fanout,
+		interval:      interval,
+		stopThreshold: stopThreshold,
+		rumorsLock:    sync.Mutex{},
+		rumors:        map[uuid.UUID]*rumor{},
+	}
+}
+
+func (e *epidemicDissemination) Spread(discoverMsg *api.DiscoverMsg, exclude map[string]struct{}) {
+	e.rumorsLock.Lock()
+	defer e.rumorsLock.Unlock()
+
+	e.rumors[discoverMsg.MessageId] = &rumor{
+		discoverMsg: discoverMsg,
+		exclude:     exclude,
+		unnecessary: 0,
+		rounds:      0,
+	}
+}
+
+func (e *epidemicDissemination) SendTable() {
+	e.node.sendServicesTableToNeighbors(pickRandomNeighbors(e.node.neighborsTable.GetAvailableNeighbors(),
+		e.fanout))
+}
+
+func (e *epidemicDissemination) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		e.doRound()
+	}
+}
+
+func (e *epidemicDissemination) doRound() {
+	targets := pickRandomNeighbors(e.node.neighborsTable.GetAvailableNeighbors(), e.fanout)
+
+	e.rumorsLock.Lock()
+	rumors := make([]*rumor, 0, len(e.rumors))
+	for _, r := range e.rumors {
+		rumors = append(rumors, r)
+	}
+	e.rumorsLock.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target *genericutils.Node) {
+			defer wg.Done()
+			e.pushRumors(target, rumors)
+			e.node.sendServicesTableToNeighbor(target)
+			e.node.syncWithNeighbor(target)
+		}(target)
+	}
+	wg.Wait()
+
+	e.rumorsLock.Lock()
+	defer e.rumorsLock.Unlock()
+
+	for _, r := range rumors {
+		r.rounds++
+		if r.unnecessary >= e.stopThreshold || r.rounds >= maxGossipRounds {
+			log.Debugf("stopped spreading rumor %s after %d rounds", r.discoverMsg.MessageId, r.rounds)
+			delete(e.rumors, r.discoverMsg.MessageId)
+		}
+	}
+}
+
+func (e *epidemicDissemination) pushRumors(target *genericutils.Node, rumors []*rumor) {
+	for _, r := range rumors {
+		if _, ok := r.exclude[target.Id]; ok {
+			continue
+		}
+
+		known, err := e.node.transport.SendDiscoverMsg(target.Addr, r.discoverMsg)
+		if err != nil {
+			log.Warnf("failed pushing rumor %s to %s: %s", r.discoverMsg.MessageId, target.Id, err)
+			continue
+		}
+
+		if known {
+			e.rumorsLock.Lock()
+			r.unnecessary++
+			e.rumorsLock.Unlock()
+		}
+	}
+}
+
+func pickRandomNeighbors(neighbors map[string]*genericutils.Node, k int) []*genericutils.Node {
+	picked := make([]*genericutils.Node, 0, len(neighbors))
+	for _, neighbor := range neighbors {
+		picked = append(picked, neighbor)
+	}
+
+	rand.Shuffle(len(picked), func(i, j int) {
+		picked[i], picked[j] = picked[j], picked[i]
+	})
+
+	if len(picked) > k {
+		picked = picked[:k]
+	}
+
+	return picked
+}
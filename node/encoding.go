@@ -0,0 +1,231 @@
+package node
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Encodings used for the payloads exchanged between archimedes nodes
+const (
+	jsonEncodingName = "json"
+	cborEncodingName = "cbor"
+
+	noCompressionName   = "none"
+	gzipCompressionName = "gzip"
+)
+
+const (
+	jsonContentType = "application/json"
+	cborContentType = "application/cbor"
+
+	gzipContentEncoding = "gzip"
+)
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported payload encoding")
+)
+
+type (
+	// payloadEncoding is how a payload is written on the wire. JSON is what every node understands and is the
+	// easiest to debug, CBOR is the compact alternative for constrained links.
+	payloadEncoding struct {
+		contentType string
+		gzip        bool
+	}
+)
+
+var (
+	jsonPayloadEncoding = payloadEncoding{
+		contentType: jsonContentType,
+		gzip:        false,
+	}
+)
+
+func newPayloadEncoding(encodingName, compressionName string) (payloadEncoding, error) {
+	encoding := jsonPayloadEncoding
+
+	switch encodingName {
+	case cborEncodingName:
+		encoding.contentType = cborContentType
+	case jsonEncodingName:
+	default:
+		return encoding, errors.New(fmt.Sprintf("unknown encoding %s", encodingName))
+	}
+
+	switch compressionName {
+	case gzipCompressionName:
+		encoding.gzip = true
+	case noCompressionName:
+	default:
+		return encoding, errors.New(fmt.Sprintf("unknown compression %s", compressionName))
+	}
+
+	log.Infof("using %s encoding for payloads", encoding)
+
+	return encoding, nil
+}
+
+func (p payloadEncoding) String() string {
+	if p.gzip {
+		return fmt.Sprintf("%s+%s", p.contentType, gzipCompressionName)
+	}
+
+	return p.contentType
+}
+
+func (p payloadEncoding) contentEncoding() string {
+	if p.gzip {
+		return gzipContentEncoding
+	}
+
+	return ""
+}
+
+func encodePayload(encoding payloadEncoding, payload interface{}) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+
+	var writer io.Writer = buffer
+	var gzipWriter *gzip.Writer
+	if encoding.gzip {
+		gzipWriter = gzip.NewWriter(buffer)
+		writer = gzipWriter
+	}
+
+	var err error
+	switch encoding.contentType {
+	case cborContentType:
+		err = cbor.NewEncoder(writer).Encode(payload)
+	case jsonContentType:
+		err = json.NewEncoder(writer).Encode(payload)
+	default:
+		return nil, errUnsupportedEncoding
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if gzipWriter != nil {
+		err = gzipWriter.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// decodePayload decodes a payload written with encodePayload. A missing content type is taken as JSON.
+func decodePayload(contentType, contentEncoding string, body io.Reader, payload interface{}) error {
+	switch contentEncoding {
+	case "", "identity":
+	case gzipContentEncoding:
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if closeErr := gzipReader.Close(); closeErr != nil {
+				log.Error(closeErr)
+			}
+		}()
+
+		body = gzipReader
+	default:
+		return errUnsupportedEncoding
+	}
+
+	mediaType := jsonContentType
+	if contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return errUnsupportedEncoding
+		}
+	}
+
+	switch mediaType {
+	case cborContentType:
+		return cbor.NewDecoder(body).Decode(payload)
+	case jsonContentType:
+		return json.NewDecoder(body).Decode(payload)
+	default:
+		return errUnsupportedEncoding
+	}
+}
+
+// replyContentType is the content type to decode a reply with. Replies that are not CBOR are JSON, even if
+// they do not say so, as is the case of the ones sent with http_utils.
+func replyContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType == cborContentType {
+		return cborContentType
+	}
+
+	return jsonContentType
+}
+
+// decodeRequestPayload decodes the request body and replies with the appropriate status if it fails
+func decodeRequestPayload(w http.ResponseWriter, r *http.Request, payload interface{}) bool {
+	err := decodePayload(r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"), r.Body, payload)
+	if err == errUnsupportedEncoding {
+		log.Warnf("%s sent payload as %s %s", r.RemoteAddr, r.Header.Get("Content-Type"),
+			r.Header.Get("Content-Encoding"))
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return false
+	} else if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// sendPayloadReplyOK replies with the first encoding in the Accept header that is supported, using JSON if
+// there is none. The reply is compressed if allowGzip is set and the client accepts it.
+func sendPayloadReplyOK(w http.ResponseWriter, r *http.Request, payload interface{}, allowGzip bool) {
+	encoding := jsonPayloadEncoding
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		if mediaType == cborContentType || mediaType == jsonContentType {
+			encoding.contentType = mediaType
+			break
+		}
+	}
+
+	encoding.gzip = allowGzip &&
+		strings.Contains(r.Header.Get("Accept-Encoding"), gzipContentEncoding)
+
+	encoded, err := encodePayload(encoding, payload)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", encoding.contentType)
+	if encoding.gzip {
+		w.Header().Set("Content-Encoding", gzipContentEncoding)
+	}
+
+	_, err = w.Write(encoded)
+	if err != nil {
+		log.Error(err)
+	}
+}
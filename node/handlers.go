@@ -0,0 +1,943 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/bruno-anjos/archimedes/api"
+	scheduler "github.com/bruno-anjos/scheduler/api"
+	genericutils "github.com/bruno-anjos/solution-utils"
+	"github.com/bruno-anjos/solution-utils/http_utils"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMaxHops = 2
+)
+
+func (n *Node) discoverHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in discoverService handler")
+
+	discoverMsg := api.DiscoverMsg{}
+	if !decodeRequestPayload(w, r, &discoverMsg) {
+		return
+	}
+
+	err := n.signer.Verify(&discoverMsg)
+	if err != nil {
+		log.Warnf("rejecting message %s from %s: %s", discoverMsg.MessageId, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !n.isPeerAllowed(r, discoverMsg.NeighborSent) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	seen := n.messagesReceived.CheckAndAdd(discoverMsg.MessageId)
+	if seen {
+		log.Debugf("repeated message %s, ignoring...", discoverMsg.MessageId)
+		w.WriteHeader(http.StatusAlreadyReported)
+		return
+	}
+
+	log.Debugf("got discover message %+v", discoverMsg)
+
+	// any message from a neighbor is as good as a heartbeat
+	n.neighborsTable.HeartbeatReceived(discoverMsg.NeighborSent)
+
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		panic(err)
+	}
+
+	dropLoopedEntries(n.id, &discoverMsg)
+	n.preprocessMessage(remoteAddr, &discoverMsg)
+	n.applyLinkCost(&discoverMsg)
+
+	discoverMsg.Tombstones = n.servicesTable.ApplyTombstones(discoverMsg.Tombstones)
+	_, beyondHorizon := n.servicesTable.UpdateTableWithDiscoverMessage(discoverMsg.NeighborSent, &discoverMsg)
+	discoverMsg.Withdrawn = n.servicesTable.WithdrawNeighborServices(discoverMsg.NeighborSent,
+		discoverMsg.Withdrawn)
+	discoverMsg.Withdrawn = append(discoverMsg.Withdrawn, beyondHorizon...)
+
+	postprocessMessage(n.id, &discoverMsg)
+	n.broadcastMsgWithHorizon(&discoverMsg)
+}
+
+func (n *Node) registerServiceHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in registerService handler")
+
+	serviceId := http_utils.ExtractPathVar(r, ServiceIdPathVar)
+
+	serviceDTO := api.ServiceDTO{}
+	err := json.NewDecoder(r.Body).Decode(&serviceDTO)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if serviceDTO.MaxHops < 0 || serviceDTO.MaxCost < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	maxHops := serviceDTO.MaxHops
+	if maxHops == 0 {
+		if serviceDTO.MaxCost > 0 {
+			maxHops = maxHopsWithMaxCost
+		} else {
+			maxHops = n.horizon
+		}
+	}
+
+	service := &api.Service{
+		Id:    serviceId,
+		Ports: serviceDTO.Ports,
+	}
+
+	_, ok := n.servicesTable.GetService(serviceId)
+	if ok {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// the service has to be newer than its tombstone, otherwise the other nodes would ignore it
+	version := api.NewVersion(n.id, n.incarnation)
+	tombstone, ok := n.servicesTable.GetTombstone(serviceId)
+	if ok && tombstone.Host == n.id && tombstone.Version.Compare(version) >= 0 {
+		version = tombstone.Version.Next()
+	}
+
+	newTableEntry := &api.ServicesTableEntryDTO{
+		Host:         n.id,
+		HostAddr:     n.address,
+		Service:      service,
+		Instances:    map[string]*api.Instance{},
+		NumberOfHops: 0,
+		MaxHops:      maxHops,
+		Version:      version,
+		Cost:         0,
+		MaxCost:      serviceDTO.MaxCost,
+		Path:         []string{},
+	}
+
+	n.servicesTable.AddService(serviceId, n.id, newTableEntry)
+	n.servicesTable.DeleteTombstone(serviceId)
+	n.tableBroadcaster.Notify()
+
+	if n.dht != nil {
+		go n.dht.Publish(serviceId)
+	}
+
+	log.Debugf("added service %s", serviceId)
+}
+
+func (n *Node) deleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in deleteService handler")
+
+	serviceId := http_utils.ExtractPathVar(r, ServiceIdPathVar)
+
+	if !n.servicesTable.IsLocalService(serviceId) {
+		// only the host of the service can delete it, otherwise its tombstone would outrank the entry of the
+		// host in every node
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	tombstone, ok := n.servicesTable.DeleteServiceWithTombstone(serviceId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	n.sendTombstones(map[string]*api.TombstoneDTO{serviceId: tombstone})
+
+	log.Debugf("deleted service %s", serviceId)
+}
+
+func (n *Node) registerServiceInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in registerServiceInstance handler")
+
+	serviceId := http_utils.ExtractPathVar(r, ServiceIdPathVar)
+
+	if !n.servicesTable.IsLocalService(serviceId) {
+		// only the host of the service can add instances to it, otherwise any node could inject instances
+		// into the services of others
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	instanceId := http_utils.ExtractPathVar(r, InstanceIdPathVar)
+
+	instanceDTO := scheduler.InstanceDTO{}
+	err := json.NewDecoder(r.Body).Decode(&instanceDTO)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ok := n.servicesTable.ServiceHasInstance(serviceId, instanceId)
+	if ok {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	var host string
+	if instanceDTO.Local {
+		host = instanceId
+	} else {
+		host, _, err = net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	instance := &api.Instance{
+		Id:              instanceId,
+		Ip:              host,
+		ServiceId:       serviceId,
+		PortTranslation: instanceDTO.PortTranslation,
+		Initialized:     instanceDTO.Static,
+		Static:          false,
+		Local:           instanceDTO.Local,
+	}
+
+	n.servicesTable.AddInstance(serviceId, instanceId, instance)
+	n.tableBroadcaster.Notify()
+	log.Debugf("added instance %s to service %s", instanceId, serviceId)
+}
+
+func (n *Node) deleteServiceInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in deleteServiceInstance handler")
+
+	serviceId := http_utils.ExtractPathVar(r, ServiceIdPathVar)
+	if !n.servicesTable.IsLocalService(serviceId) {
+		// only the host of the service can delete its instances, like adding them
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	instanceId := http_utils.ExtractPathVar(r, InstanceIdPathVar)
+	instance, ok := n.servicesTable.GetServiceInstance(serviceId, instanceId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	n.servicesTable.DeleteInstance(instance.ServiceId, instanceId)
+	n.tableBroadcaster.Notify()
+
+	log.Debugf("deleted instance %s from service %s", instanceId, serviceId)
+}
+
+func (n *Node) getAllServicesHandler(w http.ResponseWriter, _ *http.Request) {
+	log.Debug("handling request in getAllServices handler")
+
+	http_utils.SendJSONReplyOK(w, n.servicesTable.GetAllServices())
+}
+
+func (n *Node) getAllServiceInstancesHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in getAllServiceInstances handler")
+
+	serviceId := http_utils.ExtractPathVar(r, ServiceIdPathVar)
+
+	_, ok := n.servicesTable.GetService(serviceId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	http_utils.SendJSONReplyOK(w, n.servicesTable.GetAllServiceInstances(serviceId))
+}
+
+func (n *Node) getServiceInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in getServiceInstance handler")
+
+	serviceId := http_utils.ExtractPathVar(r, ServiceIdPathVar)
+	instanceId := http_utils.ExtractPathVar(r, InstanceIdPathVar)
+
+	instance, ok := n.servicesTable.GetServiceInstance(serviceId, instanceId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	http_utils.SendJSONReplyOK(w, instance)
+}
+
+func (n *Node) getInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	instanceId := http_utils.ExtractPathVar(r, InstanceIdPathVar)
+
+	instance, ok := n.servicesTable.GetInstance(instanceId)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	http_utils.SendJSONReplyOK(w, instance)
+}
+
+func (n *Node) whoAreYouHandler(w http.ResponseWriter, _ *http.Request) {
+	log.Debug("handling whoAreYou request")
+	http_utils.SendJSONReplyOK(w, n.id)
+}
+
+func (n *Node) getServicesTableHandler(w http.ResponseWriter, _ *http.Request) {
+	http_utils.SendJSONReplyOK(w, n.servicesTable.ToDiscoverMsg(n.id))
+}
+
+func (n *Node) changeServiceHorizonHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in changeServiceHorizon handler")
+
+	serviceId := http_utils.ExtractPathVar(r, ServiceIdPathVar)
+
+	horizonDTO := api.HorizonDTO{}
+	err := json.NewDecoder(r.Body).Decode(&horizonDTO)
+	if err != nil || horizonDTO.MaxHops < 0 || horizonDTO.MaxCost < 0 ||
+		(horizonDTO.MaxHops == 0 && horizonDTO.MaxCost == 0) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	maxHops := horizonDTO.MaxHops
+	if maxHops == 0 {
+		maxHops = maxHopsWithMaxCost
+	}
+
+	if !n.servicesTable.IsLocalService(serviceId) {
+		// only the host of the service can change it, otherwise the change would not reach the other nodes
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	n.servicesTable.SetServiceHorizon(serviceId, maxHops, horizonDTO.MaxCost)
+	n.tableBroadcaster.Notify()
+
+	log.Debugf("changed service %s horizon to %d hops and max cost %.2f", serviceId, maxHops,
+		horizonDTO.MaxCost)
+}
+
+func (n *Node) syncTableHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in syncTable handler")
+
+	digest := api.TableDigestDTO{}
+	if !decodeRequestPayload(w, r, &digest) {
+		return
+	}
+
+	// the reply holds the whole table, so it is only sent to the nodes with the keys
+	err := n.signer.VerifyDigest(&digest)
+	if err != nil {
+		log.Warnf("rejecting digest from %s: %s", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !n.isPeerAllowed(r, digest.Sender) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	n.neighborsTable.HeartbeatReceived(digest.Sender)
+
+	discoverMsg := n.servicesTable.DiffFromDigest(n.id, &digest)
+	n.applySplitHorizon(digest.Sender, discoverMsg)
+
+	discoverMsg, err = n.signer.Sign(discoverMsg)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sendPayloadReplyOK(w, r, discoverMsg, n.encoding.gzip)
+}
+
+func (n *Node) resolveHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("handling resolve request")
+
+	toResolve := api.ToResolveDTO{}
+	err := json.NewDecoder(r.Body).Decode(&toResolve)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var (
+		instances map[string]*api.Instance
+		hops      int
+	)
+
+	service, sOk := n.servicesTable.GetService(toResolve.Host)
+	if sOk {
+		instances = n.servicesTable.GetAllServiceInstances(service.Id)
+		hops, _ = n.servicesTable.GetServiceNumberOfHops(service.Id)
+	} else {
+		instance, iOk := n.servicesTable.GetInstance(toResolve.Host)
+		if iOk {
+			resolved, ok := resolveInstance(toResolve.Port, instance)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			resolved.Hops, _ = n.servicesTable.GetServiceNumberOfHops(instance.ServiceId)
+
+			http_utils.SendJSONReplyOK(w, resolved)
+			return
+		}
+
+		// the service may exist beyond the horizon, but the search has to end before the client gives up
+		ctx, cancel := context.WithTimeout(r.Context(), n.config.ResolveTimeout)
+		defer cancel()
+
+		entry, qOk := n.queryService(ctx, toResolve.Host)
+		if !qOk && n.dht != nil && ctx.Err() == nil {
+			entry, qOk = n.lookupServiceInDHT(ctx, toResolve.Host)
+		}
+		if !qOk {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		instances = entry.Instances
+		hops = entry.NumberOfHops
+	}
+
+	if len(instances) == 0 {
+		log.Debugf("no instances for service %s", toResolve.Host)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var randInstance *api.Instance
+	randNum := rand.Intn(len(instances))
+	for _, instance := range instances {
+		if randNum == 0 {
+			randInstance = instance
+		} else {
+			randNum--
+		}
+	}
+
+	resolved, ok := resolveInstance(toResolve.Port, randInstance)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Debugf("resolved %s:%s to %s:%s, %d hops away", toResolve.Host, toResolve.Port.Port(), resolved.Host,
+		resolved.Port, hops)
+
+	http_utils.SendJSONReplyOK(w, api.ResolvedDTO{
+		Host: resolved.Host,
+		Port: resolved.Port,
+		Hops: hops,
+	})
+}
+
+// queryHandler answers with the entry for the service if this node knows instances of it and otherwise
+// forwards the query to its other neighbors while the TTL allows
+func (n *Node) queryHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in query handler")
+
+	queryMsg := api.QueryMsg{}
+	if !decodeRequestPayload(w, r, &queryMsg) {
+		return
+	}
+
+	err := n.signer.VerifyQuery(&queryMsg)
+	if err != nil {
+		log.Warnf("rejecting query %s from %s: %s", queryMsg.QueryId, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !n.isPeerAllowed(r, queryMsg.NeighborSent) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	seen := n.messagesReceived.CheckAndAdd(queryMsg.QueryId)
+	if seen {
+		log.Debugf("repeated query %s, ignoring...", queryMsg.QueryId)
+		w.WriteHeader(http.StatusAlreadyReported)
+		return
+	}
+
+	entry, ok := n.servicesTable.GetServiceEntry(queryMsg.ServiceId)
+	if !ok {
+		entry, ok = n.queryCache.Get(queryMsg.ServiceId)
+	}
+
+	// an entry without instances does not help resolving the service, so the search goes on
+	ok = ok && len(entry.Instances) > 0
+
+	if !ok && queryMsg.TTL > 1 {
+		forwarded := queryMsg
+		forwarded.NeighborSent = n.id
+		forwarded.TTL--
+
+		exclude := map[string]struct{}{
+			queryMsg.NeighborSent: {},
+			queryMsg.Origin:       {},
+		}
+
+		entry, ok = n.queryNeighbors(r.Context(), &forwarded, exclude)
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	answer, err := n.signer.Sign(&api.DiscoverMsg{
+		MessageId:    queryMsg.QueryId,
+		Origin:       n.id,
+		NeighborSent: n.id,
+		Entries:      map[string]*api.ServicesTableEntryDTO{queryMsg.ServiceId: entry},
+	})
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sendPayloadReplyOK(w, r, answer, n.encoding.gzip)
+}
+
+func (n *Node) dhtFindHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in dhtFind handler")
+
+	if n.dht == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	findMsg := api.DHTFindMsg{}
+	if !decodeRequestPayload(w, r, &findMsg) {
+		return
+	}
+
+	if !n.isPeerAllowed(r, findMsg.Sender.Id) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// the sender is added to the routing table, so it has to be one of the nodes with the keys
+	err := n.signer.VerifyDHTFind(&findMsg)
+	if err != nil {
+		log.Warnf("rejecting dht lookup from %s: %s", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	reply := n.dht.HandleFind(contactFromRequest(r.RemoteAddr, &findMsg.Sender), &findMsg)
+
+	sendPayloadReplyOK(w, r, reply, n.encoding.gzip)
+}
+
+func (n *Node) dhtStoreHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in dhtStore handler")
+
+	if n.dht == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	storeMsg := api.DHTStoreMsg{}
+	if !decodeRequestPayload(w, r, &storeMsg) {
+		return
+	}
+
+	if !n.isPeerAllowed(r, storeMsg.Sender.Id) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	err := n.signer.VerifyDHTStore(&storeMsg)
+	if err != nil {
+		log.Warnf("rejecting dht store from %s: %s", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	n.dht.HandleStore(contactFromRequest(r.RemoteAddr, &storeMsg.Sender), &storeMsg)
+}
+
+func (n *Node) addNeighborHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in addNeighbor handler")
+
+	neighborDTO := api.NeighborDTO{}
+	err := json.NewDecoder(r.Body).Decode(&neighborDTO)
+	if err != nil || neighborDTO.Addr == "" || neighborDTO.Cost < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	neighbor, err := n.AddNeighbor(neighborDTO.Addr, neighborDTO.Cost)
+	switch err {
+	case nil:
+	case ErrNeighborIsSelf:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	case ErrNeighborExists:
+		w.WriteHeader(http.StatusConflict)
+		return
+	default:
+		log.Errorf("could not resolve neighbor at %s: %s", neighborDTO.Addr, err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	http_utils.SendJSONReplyOK(w, api.NeighborDTO{
+		Id:     neighbor.Id,
+		Addr:   neighbor.Addr,
+		Status: api.NeighborStatusAlive,
+		Cost:   n.neighborsTable.GetLinkCost(neighbor.Id),
+	})
+}
+
+func (n *Node) getAllNeighborsHandler(w http.ResponseWriter, _ *http.Request) {
+	log.Debug("handling request in getAllNeighbors handler")
+
+	http_utils.SendJSONReplyOK(w, n.neighborsTable.ToDTO())
+}
+
+func (n *Node) deleteNeighborHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in deleteNeighbor handler")
+
+	neighborId := http_utils.ExtractPathVar(r, NeighborIdPathVar)
+
+	deleted := n.neighborsTable.DeleteNeighbor(neighborId)
+	if !deleted {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	n.withdrawNeighborServices(neighborId)
+}
+
+func (n *Node) getMessagesCacheStatsHandler(w http.ResponseWriter, _ *http.Request) {
+	log.Debug("handling request in getMessagesCacheStats handler")
+
+	http_utils.SendJSONReplyOK(w, n.messagesReceived.Stats())
+}
+
+var (
+	allowedStatuses = map[string]struct{}{api.StatusOutOfService: {}, api.StatusUp: {}}
+)
+
+func (n *Node) changeInstanceStateHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debug("handling request in changeInstanceState handler")
+
+	vars := mux.Vars(r)
+	status := vars[api.StatusQueryVar]
+
+	log.Debugf("status query param: %s", status)
+
+	_, ok := allowedStatuses[status]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	// TODO
+	panic("implement me")
+}
+
+// preprocessMessage gives the entries and tombstones without a horizon the one of this node
+func (n *Node) preprocessMessage(remoteAddr string, discoverMsg *api.DiscoverMsg) {
+	for _, tombstone := range discoverMsg.Tombstones {
+		if tombstone.MaxHops <= 0 {
+			tombstone.MaxHops = n.horizon
+		}
+	}
+
+	for _, entry := range discoverMsg.Entries {
+		if entry.MaxHops <= 0 {
+			entry.MaxHops = n.horizon
+		}
+
+		if entry.Host == discoverMsg.NeighborSent {
+			fillHostAddr(remoteAddr, entry)
+		}
+	}
+}
+
+// fillHostAddr sets the address of an entry received from its own host to the one the host was reached at
+func fillHostAddr(remoteAddr string, entry *api.ServicesTableEntryDTO) {
+	entry.HostAddr = remoteAddr
+	for _, instance := range entry.Instances {
+		instance.Ip = remoteAddr
+	}
+}
+
+// dropLoopedEntries removes the entries that already went through this node, which would otherwise loop
+func dropLoopedEntries(archimedesId string, discoverMsg *api.DiscoverMsg) {
+	for serviceId, entry := range discoverMsg.Entries {
+		for _, nodeId := range entry.Path {
+			if nodeId == archimedesId {
+				log.Debugf("dropping service %s entry that looped through path %v", serviceId, entry.Path)
+				delete(discoverMsg.Entries, serviceId)
+				break
+			}
+		}
+	}
+}
+
+func postprocessMessage(archimedesId string, discoverMsg *api.DiscoverMsg) {
+	var servicesToDelete []string
+
+	for serviceId, tombstone := range discoverMsg.Tombstones {
+		tombstone.NumberOfHops++
+		if tombstone.NumberOfHops > tombstone.MaxHops {
+			servicesToDelete = append(servicesToDelete, serviceId)
+		}
+	}
+
+	for _, serviceToDelete := range servicesToDelete {
+		delete(discoverMsg.Tombstones, serviceToDelete)
+	}
+
+	servicesToDelete = nil
+
+	for serviceId, entry := range discoverMsg.Entries {
+		// forwarding the entry takes it one hop further away from its host
+		entry.NumberOfHops++
+		entry.Path = append(entry.Path, archimedesId)
+		if entry.NumberOfHops > entry.MaxHops {
+			servicesToDelete = append(servicesToDelete, serviceId)
+		}
+	}
+
+	for _, serviceToDelete := range servicesToDelete {
+		delete(discoverMsg.Entries, serviceToDelete)
+	}
+}
+
+// sendServicesTable lets the dissemination strategy pick the neighbors the changes to the table are sent to.
+// It waits for them to be sent, which is why handlers notify tableBroadcaster instead of calling it.
+func (n *Node) sendServicesTable() {
+	n.dissemination.SendTable()
+}
+
+// sendServicesTableToNeighbors sends to each neighbor only the entries that changed since the last time it
+// was sent the table and waits for them to be sent
+func (n *Node) sendServicesTableToNeighbors(neighbors []*genericutils.Node) {
+	wg := sync.WaitGroup{}
+	for _, neighbor := range neighbors {
+		wg.Add(1)
+		go func(neighbor *genericutils.Node) {
+			defer wg.Done()
+			n.sendServicesTableToNeighbor(neighbor)
+		}(neighbor)
+	}
+	wg.Wait()
+}
+
+// sendServicesTableToNeighbor falls back to sending the whole table when the neighbor is too far behind,
+// i.e. it is new, recovered from a failure or a previous send to it failed. Sends to the same neighbor do not
+// overlap, whether they come from the broadcaster, a new neighbor or a recovered one.
+func (n *Node) sendServicesTableToNeighbor(neighbor *genericutils.Node) {
+	sendLock, ok := n.neighborsTable.GetSendLock(neighbor.Id)
+	if !ok {
+		return
+	}
+
+	sendLock.Lock()
+	defer sendLock.Unlock()
+
+	sentVersions, ok := n.neighborsTable.GetSentVersions(neighbor.Id)
+	if !ok {
+		return
+	}
+
+	discoverMsg, versions := n.servicesTable.ToDeltaDiscoverMsg(n.id, sentVersions)
+	if discoverMsg != nil {
+		n.applySplitHorizon(neighbor.Id, discoverMsg)
+		if len(discoverMsg.Entries) == 0 && len(discoverMsg.Withdrawn) == 0 {
+			discoverMsg = nil
+		}
+	}
+
+	// the versions of the entries left out by split horizon are kept, since the neighbor we learned them
+	// from already has them
+	if discoverMsg == nil {
+		n.neighborsTable.SetSentVersions(neighbor.Id, versions)
+		return
+	}
+
+	if sentVersions == nil {
+		log.Debugf("sending full table to %s", neighbor.Id)
+	} else {
+		log.Debugf("sending %d changed entries to %s", len(discoverMsg.Entries), neighbor.Id)
+	}
+
+	n.messagesReceived.Add(discoverMsg.MessageId)
+
+	discoverMsg, err := n.signer.Sign(discoverMsg)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	_, err = n.transport.SendDiscoverMsg(neighbor.Addr, discoverMsg)
+	if err != nil {
+		log.Warnf("failed sending table to %s: %s", neighbor.Id, err)
+		n.neighborsTable.RequireFullSync(neighbor.Id)
+		return
+	}
+
+	n.neighborsTable.SetSentVersions(neighbor.Id, versions)
+}
+
+// applySplitHorizon leaves out of a message to the neighbor the entries learned from it, so a service it
+// withdraws does not bounce back to it from this node. With poisoned reverse they are sent as withdrawn
+// instead, which also breaks the loop if the neighbor had learned them from this node in turn.
+func (n *Node) applySplitHorizon(neighborId string, discoverMsg *api.DiscoverMsg) {
+	for serviceId := range n.servicesTable.GetNeighborServices(neighborId) {
+		if _, ok := discoverMsg.Entries[serviceId]; !ok {
+			continue
+		}
+
+		delete(discoverMsg.Entries, serviceId)
+
+		if n.config.PoisonedReverse {
+			discoverMsg.Withdrawn = append(discoverMsg.Withdrawn, serviceId)
+		}
+	}
+}
+
+func resolveInstance(originalPort nat.Port, instance *api.Instance) (*api.ResolvedDTO, bool) {
+	if instance.Local {
+		return &api.ResolvedDTO{
+			Host: instance.Id,
+			Port: originalPort.Port(),
+		}, true
+	} else {
+		portNatResolved, ok := instance.PortTranslation[originalPort]
+		if !ok {
+			return nil, false
+		}
+
+		return &api.ResolvedDTO{
+			Host: instance.Ip,
+			Port: portNatResolved[0].HostPort,
+		}, true
+	}
+}
+
+// broadcastMsgWithHorizon sends the message to the neighbors, leaving out the entries and tombstones that
+// would go beyond the horizon of their service
+func (n *Node) broadcastMsgWithHorizon(discoverMsg *api.DiscoverMsg) {
+	entries := map[string]*api.ServicesTableEntryDTO{}
+	for serviceId, entry := range discoverMsg.Entries {
+		if entry.NumberOfHops > entry.MaxHops {
+			continue
+		}
+		entries[serviceId] = entry
+	}
+
+	tombstones := map[string]*api.TombstoneDTO{}
+	for serviceId, tombstone := range discoverMsg.Tombstones {
+		if tombstone.NumberOfHops > tombstone.MaxHops {
+			continue
+		}
+		tombstones[serviceId] = tombstone
+	}
+
+	if len(entries) == 0 && len(discoverMsg.Withdrawn) == 0 && len(tombstones) == 0 {
+		log.Debugf("nothing to send from message %s within the horizon", discoverMsg.MessageId)
+		return
+	}
+
+	toSend := &api.DiscoverMsg{
+		MessageId:    discoverMsg.MessageId,
+		Origin:       discoverMsg.Origin,
+		NeighborSent: n.id,
+		Entries:      entries,
+		Withdrawn:    discoverMsg.Withdrawn,
+		Tombstones:   tombstones,
+	}
+
+	// the neighbor that sent us the message and the origin already have this information
+	exclude := map[string]struct{}{
+		discoverMsg.NeighborSent: {},
+		discoverMsg.Origin:       {},
+	}
+
+	toSend, err := n.signer.Sign(toSend)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	n.dissemination.Spread(toSend, exclude)
+}
+
+// withdrawNeighborServices removes the services learned from the neighbor and lets the other neighbors
+// know they can no longer be reached through this node
+func (n *Node) withdrawNeighborServices(neighborId string) {
+	withdrawn := n.servicesTable.DeleteNeighborServices(neighborId)
+	if len(withdrawn) == 0 {
+		return
+	}
+
+	log.Debugf("withdrawing services %+v learned from %s", withdrawn, neighborId)
+
+	n.sendWithdrawn(withdrawn)
+}
+
+// sendWithdrawn tells the neighbors this node can no longer reach the services
+func (n *Node) sendWithdrawn(withdrawn []string) {
+	discoverMsg := &api.DiscoverMsg{
+		MessageId:    uuid.New(),
+		Origin:       n.id,
+		NeighborSent: n.id,
+		Entries:      map[string]*api.ServicesTableEntryDTO{},
+		Withdrawn:    withdrawn,
+	}
+
+	n.messagesReceived.Add(discoverMsg.MessageId)
+	n.broadcastMsgWithHorizon(discoverMsg)
+}
+
+// sendTombstones lets the neighbors know about services deleted by this node
+func (n *Node) sendTombstones(tombstones map[string]*api.TombstoneDTO) {
+	toSend := map[string]*api.TombstoneDTO{}
+	for serviceId, tombstone := range tombstones {
+		tombstoneCopy := *tombstone
+		tombstoneCopy.NumberOfHops = 1
+		toSend[serviceId] = &tombstoneCopy
+	}
+
+	discoverMsg := &api.DiscoverMsg{
+		MessageId:    uuid.New(),
+		Origin:       n.id,
+		NeighborSent: n.id,
+		Entries:      map[string]*api.ServicesTableEntryDTO{},
+		Tombstones:   toSend,
+	}
+
+	n.messagesReceived.Add(discoverMsg.MessageId)
+	n.broadcastMsgWithHorizon(discoverMsg)
+}
+
+func (n *Node) sendDiscoverMsgToNeighbor(neighbor *genericutils.Node, discoverMsg *api.DiscoverMsg) {
+	log.Debugf("sending message %s to %s", discoverMsg.MessageId, neighbor.Id)
+
+	_, err := n.transport.SendDiscoverMsg(neighbor.Addr, discoverMsg)
+	if err != nil {
+		log.Warnf("failed sending message %s to %s: %s", discoverMsg.MessageId, neighbor.Id, err)
+	}
+}
@@ -0,0 +1,624 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	harnessPort         = 50000
+	harnessPollInterval = 10 * time.Millisecond
+	// linkAttempts is how many times adding a neighbor is tried, since the messages it takes can be dropped
+	linkAttempts = 10
+)
+
+var (
+	errNodeUnreachable = errors.New("node is unreachable")
+	errMessageDropped  = errors.New("message was dropped")
+)
+
+type (
+	// Network runs archimedes nodes in the same process, connected by in-memory links instead of HTTP, so
+	// tests can build a topology and check how the nodes converge. Requests still go through the routes of
+	// each node, so they are handled exactly as they would be over the network.
+	Network struct {
+		latency  time.Duration
+		dropRate float64
+
+		lock    sync.RWMutex
+		nodes   []*Node
+		routers map[string]*mux.Router
+		addrs   map[string]string
+		// links holds, for each node address, the addresses it can reach
+		links map[string]map[string]struct{}
+		// partitioned holds the links cut by Partition, so Heal only restores those
+		partitioned [][2]*Node
+		// observer, if set, sees every discover message sent between nodes, before it is delivered
+		observer DiscoverObserver
+	}
+
+	// DiscoverObserver is called with the addresses of the nodes a discover message went from and to
+	DiscoverObserver func(from, to string, discoverMsg *api.DiscoverMsg)
+
+	// memTransport is the transport of a node in a Network
+	memTransport struct {
+		network  *Network
+		addr     string
+		encoding payloadEncoding
+	}
+)
+
+func TestMain(m *testing.M) {
+	// dropped messages and failed neighbors are logged as warnings, which the tests cause on purpose
+	log.SetLevel(log.ErrorLevel)
+
+	os.Exit(m.Run())
+}
+
+// NewNetwork creates a network where every message takes latency to be delivered and is lost with
+// probability dropRate
+func NewNetwork(latency time.Duration, dropRate float64) *Network {
+	return &Network{
+		latency:     latency,
+		dropRate:    dropRate,
+		lock:        sync.RWMutex{},
+		nodes:       nil,
+		routers:     map[string]*mux.Router{},
+		addrs:       map[string]string{},
+		links:       map[string]map[string]struct{}{},
+		partitioned: nil,
+		observer:    nil,
+	}
+}
+
+// HarnessConfig returns a config with short intervals, so tests do not take long to converge
+func HarnessConfig() *Config {
+	return &Config{
+		HeartbeatInterval:     100 * time.Millisecond,
+		SuspicionTimeout:      300 * time.Millisecond,
+		FailureTimeout:        600 * time.Millisecond,
+		MessagesCacheTTL:      defaultMessagesCacheTTL,
+		MessagesCacheCapacity: defaultMessagesCacheCapacity,
+		AntiEntropyInterval:   200 * time.Millisecond,
+		TombstoneGracePeriod:  10 * time.Second,
+		RefreshInterval:       500 * time.Millisecond,
+		EntryTTL:              2 * time.Second,
+		BroadcastWindow:       10 * time.Millisecond,
+		PoisonedReverse:       false,
+		LinkCost:              hopLinkCostName,
+		QueryTTL:              defaultQueryTTL,
+		QueryCacheTTL:         time.Second,
+		ResolveTimeout:        defaultResolveTimeout,
+		DHT:                   false,
+		DHTBucketSize:         defaultDHTBucketSize,
+		Dissemination:         floodDisseminationName,
+		GossipFanout:          defaultGossipFanout,
+		GossipInterval:        100 * time.Millisecond,
+		GossipStopThreshold:   defaultGossipStopThreshold,
+		TLSConfig:             nil,
+		Encoding:              jsonEncodingName,
+		Compression:           noCompressionName,
+		HMACKeys:              map[string][]byte{},
+		HMACActiveKeyId:       "",
+	}
+}
+
+// AddNodes starts count nodes with the given config, or HarnessConfig if it is nil
+func (nw *Network) AddNodes(count int, config *Config) ([]*Node, error) {
+	if config == nil {
+		config = HarnessConfig()
+	}
+
+	encoding, err := newPayloadEncoding(config.Encoding, config.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	nw.lock.Lock()
+	defer nw.lock.Unlock()
+
+	added := make([]*Node, 0, count)
+	for i := 0; i < count; i++ {
+		index := len(nw.nodes)
+		id := fmt.Sprintf("node-%d", index)
+		addr := fmt.Sprintf("10.0.%d.%d:%d", index/256, index%256, harnessPort)
+
+		n, err := New(WithId(id), WithAddress(addr), WithConfig(config), WithTransport(&memTransport{
+			network:  nw,
+			addr:     addr,
+			encoding: encoding,
+		}))
+		if err != nil {
+			return nil, err
+		}
+
+		nw.nodes = append(nw.nodes, n)
+		nw.routers[addr] = newRouter(n.routes())
+		nw.addrs[id] = addr
+		nw.links[addr] = map[string]struct{}{}
+
+		n.Start()
+		added = append(added, n)
+	}
+
+	return added, nil
+}
+
+func (nw *Network) Nodes() []*Node {
+	nw.lock.RLock()
+	defer nw.lock.RUnlock()
+
+	nodes := make([]*Node, len(nw.nodes))
+	copy(nodes, nw.nodes)
+
+	return nodes
+}
+
+func (nw *Network) Addr(n *Node) string {
+	nw.lock.RLock()
+	defer nw.lock.RUnlock()
+
+	return nw.addrs[n.id]
+}
+
+// Link connects the nodes and makes them neighbors of each other
+func (nw *Network) Link(a, b *Node) error {
+	return nw.LinkWithCost(a, b, 0)
+}
+
+// LinkWithCost links the nodes setting the cost of the link in both of them, or leaving the measured or
+// default one if cost is 0
+func (nw *Network) LinkWithCost(a, b *Node, cost float64) error {
+	nw.setLink(a, b, true)
+
+	for _, pair := range [][2]*Node{{a, b}, {b, a}} {
+		neighborDTO := api.NeighborDTO{
+			Addr: nw.Addr(pair[1]),
+			Cost: cost,
+		}
+
+		var (
+			status int
+			err    error
+		)
+		for attempt := 0; attempt < linkAttempts; attempt++ {
+			status, err = nw.Do(pair[0], http.MethodPost, api.GetNeighborsPath(), neighborDTO, nil)
+			if err != nil {
+				return err
+			}
+
+			if status != http.StatusBadGateway {
+				break
+			}
+		}
+
+		if status != http.StatusOK && status != http.StatusConflict {
+			return errors.New(fmt.Sprintf("got status %d adding %s as neighbor of %s", status, pair[1].id,
+				pair[0].id))
+		}
+	}
+
+	return nil
+}
+
+// Cut breaks the link between the nodes without telling them, as a network failure would
+func (nw *Network) Cut(a, b *Node) {
+	nw.setLink(a, b, false)
+}
+
+// Restore brings back a link broken with Cut
+func (nw *Network) Restore(a, b *Node) {
+	nw.setLink(a, b, true)
+}
+
+// Partition cuts every link between nodes in different sides
+func (nw *Network) Partition(sides ...[]*Node) {
+	for i := range sides {
+		for j := i + 1; j < len(sides); j++ {
+			for _, a := range sides[i] {
+				for _, b := range sides[j] {
+					if !nw.linked(a, b) {
+						continue
+					}
+
+					nw.Cut(a, b)
+
+					nw.lock.Lock()
+					nw.partitioned = append(nw.partitioned, [2]*Node{a, b})
+					nw.lock.Unlock()
+				}
+			}
+		}
+	}
+}
+
+// Heal restores the links cut by Partition
+func (nw *Network) Heal() {
+	nw.lock.Lock()
+	partitioned := nw.partitioned
+	nw.partitioned = nil
+	nw.lock.Unlock()
+
+	for _, pair := range partitioned {
+		nw.Restore(pair[0], pair[1])
+	}
+}
+
+// ReachAll lets every node reach every other one without making them neighbors, as in an IP network where
+// only some nodes are configured as neighbors. The DHT needs it to contact nodes that are not neighbors.
+func (nw *Network) ReachAll() {
+	nodes := nw.Nodes()
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			nw.setLink(nodes[i], nodes[j], true)
+		}
+	}
+}
+
+// ConnectLine links every node to the next one
+func (nw *Network) ConnectLine() error {
+	nodes := nw.Nodes()
+	for i := 0; i+1 < len(nodes); i++ {
+		if err := nw.Link(nodes[i], nodes[i+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ConnectRing links every node to the next one and the last one to the first
+func (nw *Network) ConnectRing() error {
+	err := nw.ConnectLine()
+	if err != nil {
+		return err
+	}
+
+	nodes := nw.Nodes()
+	if len(nodes) < 3 {
+		return nil
+	}
+
+	return nw.Link(nodes[len(nodes)-1], nodes[0])
+}
+
+// ConnectFull links every node to every other node
+func (nw *Network) ConnectFull() error {
+	nodes := nw.Nodes()
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			if err := nw.Link(nodes[i], nodes[j]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Stop stops every node in the network
+func (nw *Network) Stop() {
+	for _, n := range nw.Nodes() {
+		n.Stop()
+	}
+}
+
+// RegisterService registers a service in the node, as the deployer would
+func (nw *Network) RegisterService(n *Node, serviceId string, maxHops int) error {
+	serviceDTO := api.ServiceDTO{
+		MaxHops: maxHops,
+	}
+
+	status, err := nw.Do(n, http.MethodPost, api.GetServicePath(serviceId), serviceDTO, nil)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return errors.New(fmt.Sprintf("got status %d registering service %s in %s", status, serviceId, n.id))
+	}
+
+	return nil
+}
+
+func (nw *Network) DeleteService(n *Node, serviceId string) error {
+	status, err := nw.Do(n, http.MethodDelete, api.GetServicePath(serviceId), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return errors.New(fmt.Sprintf("got status %d deleting service %s in %s", status, serviceId, n.id))
+	}
+
+	return nil
+}
+
+// Do sends a request to the node as a client would, i.e. without going through any link
+func (nw *Network) Do(n *Node, method, path string, body, responseBody interface{}) (int, error) {
+	return nw.serve(context.Background(), "", nw.Addr(n), method, path, body, responseBody, jsonPayloadEncoding)
+}
+
+// serve hands the request to the router of the node at addr. If from is set the request comes from another
+// node, so it has to go through a link.
+func (nw *Network) serve(ctx context.Context, from, addr, method, path string, body,
+	responseBody interface{}, encoding payloadEncoding) (int, error) {
+	nw.lock.RLock()
+	router, ok := nw.routers[addr]
+	_, linked := nw.links[from][addr]
+	nw.lock.RUnlock()
+
+	if !ok || (from != "" && !linked) {
+		return 0, errNodeUnreachable
+	}
+
+	if from != "" {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(nw.latency):
+		}
+
+		if rand.Float64() < nw.dropRate {
+			return 0, errMessageDropped
+		}
+	}
+
+	var encoded []byte
+	if body != nil {
+		var err error
+		encoded, err = encodePayload(encoding, body)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(encoded)).WithContext(ctx)
+	req.Header.Set("Content-Type", encoding.contentType)
+	req.Header.Set("Accept", encoding.contentType)
+	if encoding.gzip {
+		req.Header.Set("Content-Encoding", encoding.contentEncoding())
+		req.Header.Set("Accept-Encoding", gzipContentEncoding)
+	}
+	if from != "" {
+		req.RemoteAddr = from
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	if responseBody != nil && recorder.Code == http.StatusOK {
+		err := decodePayload(replyContentType(recorder.Header().Get("Content-Type")),
+			recorder.Header().Get("Content-Encoding"), recorder.Body, responseBody)
+		if err != nil {
+			return recorder.Code, err
+		}
+	}
+
+	return recorder.Code, nil
+}
+
+// Observe makes observer see the discover messages sent from now on
+func (nw *Network) Observe(observer DiscoverObserver) {
+	nw.lock.Lock()
+	defer nw.lock.Unlock()
+
+	nw.observer = observer
+}
+
+func (nw *Network) linked(a, b *Node) bool {
+	nw.lock.RLock()
+	defer nw.lock.RUnlock()
+
+	_, ok := nw.links[nw.addrs[a.id]][nw.addrs[b.id]]
+	return ok
+}
+
+func (nw *Network) setLink(a, b *Node, linked bool) {
+	nw.lock.Lock()
+	defer nw.lock.Unlock()
+
+	addrA, addrB := nw.addrs[a.id], nw.addrs[b.id]
+	if linked {
+		nw.links[addrA][addrB] = struct{}{}
+		nw.links[addrB][addrA] = struct{}{}
+	} else {
+		delete(nw.links[addrA], addrB)
+		delete(nw.links[addrB], addrA)
+	}
+}
+
+func (t *memTransport) SendDiscoverMsg(addr string, discoverMsg *api.DiscoverMsg) (known bool, err error) {
+	t.network.lock.RLock()
+	observer := t.network.observer
+	t.network.lock.RUnlock()
+
+	if observer != nil {
+		observer(t.addr, addr, discoverMsg)
+	}
+
+	status, err := t.network.serve(context.Background(), t.addr, addr, http.MethodPost,
+		api.GetDiscoverPath(), discoverMsg, nil, t.encoding)
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return false, nil
+	case http.StatusAlreadyReported:
+		return true, nil
+	default:
+		return false, errors.New(fmt.Sprintf("got status %d while sending discover message to %s", status,
+			addr))
+	}
+}
+
+func (t *memTransport) WhoAreYou(addr string) (string, error) {
+	var id string
+	status, err := t.network.serve(context.Background(), t.addr, addr, http.MethodGet,
+		api.GetWhoAreYouPath(), nil, &id, t.encoding)
+	if err != nil {
+		return "", err
+	}
+
+	if status != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("got status %d while asking %s who it is", status, addr))
+	}
+
+	return id, nil
+}
+
+func (t *memTransport) SyncTable(addr string, digest *api.TableDigestDTO) (*api.DiscoverMsg, error) {
+	discoverMsg := &api.DiscoverMsg{}
+	status, err := t.network.serve(context.Background(), t.addr, addr, http.MethodPost,
+		api.GetTablePath(), digest, discoverMsg, t.encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("got status %d while syncing table with %s", status, addr))
+	}
+
+	return discoverMsg, nil
+}
+
+func (t *memTransport) Query(ctx context.Context, addr string, queryMsg *api.QueryMsg) (
+	answer *api.DiscoverMsg, found bool, err error) {
+	answer = &api.DiscoverMsg{}
+	status, err := t.network.serve(ctx, t.addr, addr, http.MethodPost, api.GetQueryPath(), queryMsg, answer,
+		t.encoding)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return answer, true, nil
+	case http.StatusNotFound, http.StatusAlreadyReported:
+		return nil, false, nil
+	default:
+		return nil, false, errors.New(fmt.Sprintf("got status %d while querying %s", status, addr))
+	}
+}
+
+func (t *memTransport) DHTFind(ctx context.Context, addr string, findMsg *api.DHTFindMsg) (
+	*api.DHTFindReplyDTO, error) {
+	reply := &api.DHTFindReplyDTO{}
+	status, err := t.network.serve(ctx, t.addr, addr, http.MethodPost, api.GetDHTFindPath(), findMsg, reply,
+		t.encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("got status %d while looking up key in %s", status, addr))
+	}
+
+	return reply, nil
+}
+
+func (t *memTransport) DHTStore(addr string, storeMsg *api.DHTStoreMsg) error {
+	status, err := t.network.serve(context.Background(), t.addr, addr, http.MethodPost,
+		api.GetDHTStorePath(), storeMsg, nil, t.encoding)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return errors.New(fmt.Sprintf("got status %d while storing service %s in %s", status,
+			storeMsg.ServiceId, addr))
+	}
+
+	return nil
+}
+
+// KnownServices returns the ids of the services in the table of the node, sorted
+func KnownServices(n *Node) []string {
+	var serviceIds []string
+	for serviceId := range n.servicesTable.GetAllServices() {
+		serviceIds = append(serviceIds, serviceId)
+	}
+
+	sort.Strings(serviceIds)
+
+	return serviceIds
+}
+
+// Knows checks if the node has the service in its table
+func Knows(n *Node, serviceId string) bool {
+	_, ok := n.servicesTable.GetService(serviceId)
+	return ok
+}
+
+// Converged checks if the nodes have the entries they should have, as described in Inconsistencies
+func Converged(nodes ...*Node) bool {
+	return len(Inconsistencies(nodes...)) == 0
+}
+
+// WaitUntil polls condition until it holds or timeout expires, returning whether it held
+func WaitUntil(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if condition() {
+			return true
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(harnessPollInterval)
+	}
+}
+
+// AssertConverged fails the test if the nodes do not converge within timeout
+func AssertConverged(t testing.TB, timeout time.Duration, nodes ...*Node) {
+	t.Helper()
+
+	if !WaitUntil(timeout, func() bool { return Converged(nodes...) }) {
+		for _, n := range nodes {
+			t.Logf("%s knows %v", n.id, KnownServices(n))
+		}
+		t.Fatalf("nodes did not converge within %s", timeout)
+	}
+}
+
+// AssertKnows fails the test if every node does not learn the service within timeout
+func AssertKnows(t testing.TB, timeout time.Duration, serviceId string, nodes ...*Node) {
+	t.Helper()
+
+	for _, n := range nodes {
+		n := n
+		if !WaitUntil(timeout, func() bool { return Knows(n, serviceId) }) {
+			t.Fatalf("%s did not learn service %s within %s", n.id, serviceId, timeout)
+		}
+	}
+}
+
+// AssertNotKnows fails the test if any node still has the service after timeout
+func AssertNotKnows(t testing.TB, timeout time.Duration, serviceId string, nodes ...*Node) {
+	t.Helper()
+
+	for _, n := range nodes {
+		n := n
+		if !WaitUntil(timeout, func() bool { return !Knows(n, serviceId) }) {
+			t.Fatalf("%s still knows service %s after %s", n.id, serviceId, timeout)
+		}
+	}
+}
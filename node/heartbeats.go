@@ -0,0 +1,66 @@
+package node
+
+import (
+	"time"
+
+	genericutils "github.com/bruno-anjos/solution-utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHeartbeatInterval = 5 * time.Second
+	defaultSuspicionTimeout  = 15 * time.Second
+	defaultFailureTimeout    = 30 * time.Second
+)
+
+// monitorNeighbors periodically sends heartbeats to every neighbor and withdraws the services learned
+// from the ones that stop answering
+func (n *Node) monitorNeighbors() {
+	if n.config.FailureTimeout < n.config.SuspicionTimeout {
+		log.Warnf("failure timeout %s is shorter than suspicion timeout %s", n.config.FailureTimeout,
+			n.config.SuspicionTimeout)
+	}
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, neighbor := range n.neighborsTable.GetAllNeighbors() {
+			go n.sendHeartbeat(neighbor)
+		}
+
+		failed := n.neighborsTable.CheckNeighbors(n.config.SuspicionTimeout, n.config.FailureTimeout)
+		for _, neighborId := range failed {
+			n.withdrawNeighborServices(neighborId)
+		}
+	}
+}
+
+func (n *Node) sendHeartbeat(neighbor *genericutils.Node) {
+	start := time.Now()
+	neighborId, err := n.transport.WhoAreYou(neighbor.Addr)
+	rtt := time.Since(start)
+	if err != nil {
+		log.Debugf("heartbeat to %s failed: %s", neighbor.Id, err)
+		return
+	}
+
+	// a restarted archimedes comes back with a different id, so it does not count as the same neighbor
+	if neighborId != neighbor.Id {
+		log.Warnf("expected %s at %s, got %s", neighbor.Id, neighbor.Addr, neighborId)
+		return
+	}
+
+	n.neighborsTable.RTTMeasured(neighbor.Id, rtt, n.config.LinkCost == rttLinkCostName)
+
+	recovered := n.neighborsTable.HeartbeatReceived(neighbor.Id)
+	if recovered {
+		n.sendServicesTableToNeighbor(neighbor)
+	}
+}
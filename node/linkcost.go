@@ -0,0 +1,54 @@
+package node
+
+import (
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Link cost metrics
+const (
+	// hopLinkCostName gives every link the same cost, so the cost of a path is its number of hops
+	hopLinkCostName = "hop"
+	// rttLinkCostName uses the RTT of the heartbeats, in milliseconds, as the cost of the links
+	rttLinkCostName = "rtt"
+)
+
+const (
+	defaultLinkCost = 1
+	// rttSmoothingFactor is the inverse of the weight of each new RTT sample, as in TCP
+	rttSmoothingFactor = 8
+	// maxHopsWithMaxCost bounds how far a service whose horizon is only a max cost goes, in case the costs
+	// are too low for it to matter
+	maxHopsWithMaxCost = 16
+)
+
+// linkCostFromRTT uses the RTT in milliseconds, but never lower than the default cost, so links with
+// negligible RTTs cost the same as a hop
+func linkCostFromRTT(rtt time.Duration) float64 {
+	cost := float64(rtt) / float64(time.Millisecond)
+	if cost < defaultLinkCost {
+		return defaultLinkCost
+	}
+
+	return cost
+}
+
+// applyLinkCost adds the cost of the link to the neighbor that sent the message to its entries, so they
+// have the cost from this node, and leaves out the entries that went beyond their max cost. Those are
+// withdrawn instead, in case this node learned them from the neighbor before their max cost shrank.
+func (n *Node) applyLinkCost(discoverMsg *api.DiscoverMsg) {
+	linkCost := n.neighborsTable.GetLinkCost(discoverMsg.NeighborSent)
+
+	for serviceId, entry := range discoverMsg.Entries {
+		entry.Cost += linkCost
+
+		if entry.MaxCost > 0 && entry.Cost > entry.MaxCost {
+			log.Debugf("service %s is beyond its max cost %.2f from here (%.2f)", serviceId, entry.MaxCost,
+				entry.Cost)
+			delete(discoverMsg.Entries, serviceId)
+			discoverMsg.Withdrawn = append(discoverMsg.Withdrawn, serviceId)
+		}
+	}
+}
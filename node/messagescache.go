@@ -0,0 +1,147 @@
+package node
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMessagesCacheTTL      = 5 * time.Minute
+	defaultMessagesCacheCapacity = 10000
+)
+
+type (
+	messagesCacheEntry struct {
+		messageId uuid.UUID
+		addedAt   time.Time
+	}
+
+	// MessagesCache remembers the ids of the messages received so repeated ones can be ignored. Ids are
+	// forgotten after a ttl and, when the capacity is reached, the oldest ones are evicted first.
+	MessagesCache struct {
+		ttl      time.Duration
+		capacity int
+
+		lock     sync.Mutex
+		order    *list.List
+		messages map[uuid.UUID]*list.Element
+
+		hits        uint64
+		misses      uint64
+		evictions   uint64
+		expirations uint64
+	}
+)
+
+func NewMessagesCache(ttl time.Duration, capacity int) *MessagesCache {
+	return &MessagesCache{
+		ttl:      ttl,
+		capacity: capacity,
+		lock:     sync.Mutex{},
+		order:    list.New(),
+		messages: map[uuid.UUID]*list.Element{},
+	}
+}
+
+// CheckAndAdd adds the message id to the cache, returning whether it was already there
+func (mc *MessagesCache) CheckAndAdd(messageId uuid.UUID) (seen bool) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	now := time.Now()
+	mc.removeExpired(now)
+
+	_, seen = mc.messages[messageId]
+	if seen {
+		mc.hits++
+		return
+	}
+
+	mc.misses++
+	mc.add(messageId, now)
+
+	return
+}
+
+// Add adds the message id to the cache, e.g. for messages sent by this node
+func (mc *MessagesCache) Add(messageId uuid.UUID) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	now := time.Now()
+	mc.removeExpired(now)
+
+	if _, ok := mc.messages[messageId]; ok {
+		return
+	}
+
+	mc.add(messageId, now)
+}
+
+func (mc *MessagesCache) Len() int {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	return mc.order.Len()
+}
+
+func (mc *MessagesCache) Stats() *api.MessagesCacheStatsDTO {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	mc.removeExpired(time.Now())
+
+	return &api.MessagesCacheStatsDTO{
+		Size:        mc.order.Len(),
+		Capacity:    mc.capacity,
+		Hits:        mc.hits,
+		Misses:      mc.misses,
+		Evictions:   mc.evictions,
+		Expirations: mc.expirations,
+	}
+}
+
+// add expects the lock to be held
+func (mc *MessagesCache) add(messageId uuid.UUID, now time.Time) {
+	for mc.order.Len() >= mc.capacity {
+		oldest := mc.order.Front()
+		mc.remove(oldest)
+		mc.evictions++
+	}
+
+	element := mc.order.PushBack(&messagesCacheEntry{
+		messageId: messageId,
+		addedAt:   now,
+	})
+	mc.messages[messageId] = element
+}
+
+// removeExpired expects the lock to be held. Since entries are kept in insertion order it only has to
+// look at the front of the list.
+func (mc *MessagesCache) removeExpired(now time.Time) {
+	for {
+		oldest := mc.order.Front()
+		if oldest == nil {
+			return
+		}
+
+		entry := oldest.Value.(*messagesCacheEntry)
+		if now.Sub(entry.addedAt) < mc.ttl {
+			return
+		}
+
+		log.Debugf("message %s expired", entry.messageId)
+		mc.remove(oldest)
+		mc.expirations++
+	}
+}
+
+func (mc *MessagesCache) remove(element *list.Element) {
+	entry := mc.order.Remove(element).(*messagesCacheEntry)
+	delete(mc.messages, entry.messageId)
+}
@@ -0,0 +1,75 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMessagesCacheCheckAndAdd(t *testing.T) {
+	cache := NewMessagesCache(time.Minute, 10)
+
+	messageId := uuid.New()
+	if cache.CheckAndAdd(messageId) {
+		t.Fatal("new message reported as seen")
+	}
+
+	if !cache.CheckAndAdd(messageId) {
+		t.Fatal("repeated message not reported as seen")
+	}
+
+	// messages sent by the node count as seen but not as a hit or a miss
+	sentId := uuid.New()
+	cache.Add(sentId)
+	if !cache.CheckAndAdd(sentId) {
+		t.Fatal("sent message not reported as seen")
+	}
+
+	stats := cache.Stats()
+	if stats.Size != 2 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("expected size 2 with 2 hits and 1 miss, got %+v", stats)
+	}
+}
+
+func TestMessagesCacheEviction(t *testing.T) {
+	cache := NewMessagesCache(time.Minute, 2)
+
+	messageIds := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, messageId := range messageIds {
+		cache.Add(messageId)
+	}
+
+	stats := cache.Stats()
+	if stats.Size != 2 || stats.Capacity != 2 || stats.Evictions != 1 {
+		t.Fatalf("expected size 2 with 1 eviction, got %+v", stats)
+	}
+
+	// the oldest message is the one evicted
+	if !cache.CheckAndAdd(messageIds[2]) || !cache.CheckAndAdd(messageIds[1]) {
+		t.Fatal("newest messages were evicted")
+	}
+
+	if cache.CheckAndAdd(messageIds[0]) {
+		t.Fatal("oldest message was not evicted")
+	}
+}
+
+func TestMessagesCacheExpiration(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	cache := NewMessagesCache(ttl, 10)
+
+	messageId := uuid.New()
+	cache.Add(messageId)
+
+	time.Sleep(2 * ttl)
+
+	if cache.CheckAndAdd(messageId) {
+		t.Fatal("expired message reported as seen")
+	}
+
+	stats := cache.Stats()
+	if stats.Size != 1 || stats.Expirations != 1 || stats.Evictions != 0 {
+		t.Fatalf("expected size 1 with 1 expiration, got %+v", stats)
+	}
+}
@@ -0,0 +1,336 @@
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	genericutils "github.com/bruno-anjos/solution-utils"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	NeighborsTableEntry struct {
+		Node          *genericutils.Node
+		Status        string
+		LastHeartbeat time.Time
+		// SentVersions holds the version of each service last sent to this neighbor
+		SentVersions map[string]api.Version
+		// NeedsFullSync is set when the neighbor may have missed messages, so deltas are not enough
+		NeedsFullSync bool
+		// Cost is the cost of the link to the neighbor, either the one configured or the one measured
+		Cost           float64
+		CostConfigured bool
+		// RTT is the smoothed round trip time of the heartbeats to the neighbor
+		RTT time.Duration
+		// SendLock is held while sending the table to the neighbor, so two sends do not compute their deltas
+		// from the same sent versions and one of them loses the versions of the other
+		SendLock  *sync.Mutex
+		EntryLock *sync.RWMutex
+	}
+)
+
+// NewNeighborsTableEntry uses the default link cost, which may be replaced by the measured one, if cost is
+// not positive
+func NewNeighborsTableEntry(neighbor *genericutils.Node, cost float64) *NeighborsTableEntry {
+	costConfigured := cost > 0
+	if !costConfigured {
+		cost = defaultLinkCost
+	}
+
+	return &NeighborsTableEntry{
+		Node:           neighbor,
+		Status:         api.NeighborStatusAlive,
+		LastHeartbeat:  time.Now(),
+		SentVersions:   map[string]api.Version{},
+		NeedsFullSync:  true,
+		Cost:           cost,
+		CostConfigured: costConfigured,
+		RTT:            0,
+		SendLock:       &sync.Mutex{},
+		EntryLock:      &sync.RWMutex{},
+	}
+}
+
+func (ne *NeighborsTableEntry) ToDTO() *api.NeighborDTO {
+	ne.EntryLock.RLock()
+	defer ne.EntryLock.RUnlock()
+
+	return &api.NeighborDTO{
+		Id:     ne.Node.Id,
+		Addr:   ne.Node.Addr,
+		Status: ne.Status,
+		Cost:   ne.Cost,
+	}
+}
+
+type (
+	NeighborsTable struct {
+		addLock      sync.Mutex
+		neighborsMap sync.Map
+	}
+
+	typeNeighborsMapKey   = string
+	typeNeighborsMapValue = *NeighborsTableEntry
+)
+
+func NewNeighborsTable() *NeighborsTable {
+	return &NeighborsTable{
+		addLock:      sync.Mutex{},
+		neighborsMap: sync.Map{},
+	}
+}
+
+func (nt *NeighborsTable) AddNeighbor(neighbor *genericutils.Node, cost float64) (added bool) {
+	nt.addLock.Lock()
+	defer nt.addLock.Unlock()
+
+	_, ok := nt.neighborsMap.Load(neighbor.Id)
+	if ok {
+		added = false
+		return
+	}
+
+	nt.neighborsMap.Store(neighbor.Id, NewNeighborsTableEntry(neighbor, cost))
+	added = true
+
+	log.Debugf("added neighbor %s at %s", neighbor.Id, neighbor.Addr)
+
+	return
+}
+
+func (nt *NeighborsTable) GetNeighbor(neighborId string) (neighbor *genericutils.Node, ok bool) {
+	value, ok := nt.neighborsMap.Load(neighborId)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(typeNeighborsMapValue).Node, true
+}
+
+func (nt *NeighborsTable) HasNeighbor(neighborId string) bool {
+	_, ok := nt.neighborsMap.Load(neighborId)
+	return ok
+}
+
+func (nt *NeighborsTable) GetAllNeighbors() map[string]*genericutils.Node {
+	neighbors := map[string]*genericutils.Node{}
+
+	nt.neighborsMap.Range(func(key, value interface{}) bool {
+		neighborId := key.(typeNeighborsMapKey)
+		entry := value.(typeNeighborsMapValue)
+
+		neighbors[neighborId] = entry.Node
+
+		return true
+	})
+
+	return neighbors
+}
+
+// GetAvailableNeighbors returns the neighbors that were not declared dead by the failure detector
+func (nt *NeighborsTable) GetAvailableNeighbors() map[string]*genericutils.Node {
+	neighbors := map[string]*genericutils.Node{}
+
+	nt.neighborsMap.Range(func(key, value interface{}) bool {
+		neighborId := key.(typeNeighborsMapKey)
+		entry := value.(typeNeighborsMapValue)
+
+		entry.EntryLock.RLock()
+		defer entry.EntryLock.RUnlock()
+
+		if entry.Status != api.NeighborStatusDead {
+			neighbors[neighborId] = entry.Node
+		}
+
+		return true
+	})
+
+	return neighbors
+}
+
+func (nt *NeighborsTable) DeleteNeighbor(neighborId string) (deleted bool) {
+	nt.addLock.Lock()
+	defer nt.addLock.Unlock()
+
+	_, ok := nt.neighborsMap.Load(neighborId)
+	if !ok {
+		deleted = false
+		return
+	}
+
+	nt.neighborsMap.Delete(neighborId)
+	deleted = true
+
+	log.Debugf("deleted neighbor %s", neighborId)
+
+	return
+}
+
+// HeartbeatReceived marks the neighbor as alive. It returns whether the neighbor had been declared dead
+// before.
+func (nt *NeighborsTable) HeartbeatReceived(neighborId string) (recovered bool) {
+	value, ok := nt.neighborsMap.Load(neighborId)
+	if !ok {
+		return false
+	}
+
+	entry := value.(typeNeighborsMapValue)
+	entry.EntryLock.Lock()
+	defer entry.EntryLock.Unlock()
+
+	recovered = entry.Status == api.NeighborStatusDead
+	if recovered {
+		entry.NeedsFullSync = true
+	}
+	if entry.Status != api.NeighborStatusAlive {
+		log.Infof("neighbor %s is %s again", neighborId, api.NeighborStatusAlive)
+	}
+
+	entry.Status = api.NeighborStatusAlive
+	entry.LastHeartbeat = time.Now()
+
+	return
+}
+
+// CheckNeighbors updates the status of every neighbor according to the time elapsed since its last
+// heartbeat and returns the ones that were declared dead by this check.
+func (nt *NeighborsTable) CheckNeighbors(suspicionTimeout, failureTimeout time.Duration) (failed []string) {
+	nt.neighborsMap.Range(func(key, value interface{}) bool {
+		neighborId := key.(typeNeighborsMapKey)
+		entry := value.(typeNeighborsMapValue)
+
+		entry.EntryLock.Lock()
+		defer entry.EntryLock.Unlock()
+
+		elapsed := time.Since(entry.LastHeartbeat)
+
+		switch {
+		case elapsed > failureTimeout && entry.Status != api.NeighborStatusDead:
+			log.Warnf("neighbor %s failed, no heartbeat for %s", neighborId, elapsed)
+			entry.Status = api.NeighborStatusDead
+			failed = append(failed, neighborId)
+		case elapsed > suspicionTimeout && entry.Status == api.NeighborStatusAlive:
+			log.Warnf("suspecting neighbor %s, no heartbeat for %s", neighborId, elapsed)
+			entry.Status = api.NeighborStatusSuspected
+		}
+
+		return true
+	})
+
+	return
+}
+
+// GetSendLock returns the lock held while sending the table to the neighbor
+func (nt *NeighborsTable) GetSendLock(neighborId string) (sendLock *sync.Mutex, ok bool) {
+	value, ok := nt.neighborsMap.Load(neighborId)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(typeNeighborsMapValue).SendLock, true
+}
+
+// GetSentVersions returns a copy of the versions last sent to the neighbor, or nil if it needs a full sync
+func (nt *NeighborsTable) GetSentVersions(neighborId string) (sentVersions map[string]api.Version, ok bool) {
+	value, ok := nt.neighborsMap.Load(neighborId)
+	if !ok {
+		return nil, false
+	}
+
+	entry := value.(typeNeighborsMapValue)
+	entry.EntryLock.RLock()
+	defer entry.EntryLock.RUnlock()
+
+	if entry.NeedsFullSync {
+		return nil, true
+	}
+
+	sentVersions = make(map[string]api.Version, len(entry.SentVersions))
+	for serviceId, version := range entry.SentVersions {
+		sentVersions[serviceId] = version
+	}
+
+	return sentVersions, true
+}
+
+// SetSentVersions replaces the versions the neighbor is known to have after a successful send
+func (nt *NeighborsTable) SetSentVersions(neighborId string, sentVersions map[string]api.Version) {
+	value, ok := nt.neighborsMap.Load(neighborId)
+	if !ok {
+		return
+	}
+
+	entry := value.(typeNeighborsMapValue)
+	entry.EntryLock.Lock()
+	defer entry.EntryLock.Unlock()
+
+	entry.SentVersions = sentVersions
+	entry.NeedsFullSync = false
+}
+
+// RequireFullSync makes the next send to the neighbor carry the whole table
+func (nt *NeighborsTable) RequireFullSync(neighborId string) {
+	value, ok := nt.neighborsMap.Load(neighborId)
+	if !ok {
+		return
+	}
+
+	entry := value.(typeNeighborsMapValue)
+	entry.EntryLock.Lock()
+	defer entry.EntryLock.Unlock()
+
+	entry.NeedsFullSync = true
+}
+
+// GetLinkCost returns the cost of the link to the neighbor, or the default cost if it is not a neighbor
+func (nt *NeighborsTable) GetLinkCost(neighborId string) float64 {
+	value, ok := nt.neighborsMap.Load(neighborId)
+	if !ok {
+		return defaultLinkCost
+	}
+
+	entry := value.(typeNeighborsMapValue)
+	entry.EntryLock.RLock()
+	defer entry.EntryLock.RUnlock()
+
+	return entry.Cost
+}
+
+// RTTMeasured adds a sample to the smoothed RTT of the neighbor and, if costFromRTT is set and the cost of
+// the link was not configured, makes it the cost of the link
+func (nt *NeighborsTable) RTTMeasured(neighborId string, rtt time.Duration, costFromRTT bool) {
+	value, ok := nt.neighborsMap.Load(neighborId)
+	if !ok {
+		return
+	}
+
+	entry := value.(typeNeighborsMapValue)
+	entry.EntryLock.Lock()
+	defer entry.EntryLock.Unlock()
+
+	if entry.RTT == 0 {
+		entry.RTT = rtt
+	} else {
+		entry.RTT += (rtt - entry.RTT) / rttSmoothingFactor
+	}
+
+	if costFromRTT && !entry.CostConfigured {
+		entry.Cost = linkCostFromRTT(entry.RTT)
+	}
+}
+
+func (nt *NeighborsTable) ToDTO() map[string]*api.NeighborDTO {
+	neighbors := map[string]*api.NeighborDTO{}
+
+	nt.neighborsMap.Range(func(key, value interface{}) bool {
+		neighborId := key.(typeNeighborsMapKey)
+		entry := value.(typeNeighborsMapValue)
+
+		neighbors[neighborId] = entry.ToDTO()
+
+		return true
+	})
+
+	return neighbors
+}
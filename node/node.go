@@ -0,0 +1,270 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	genericutils "github.com/bruno-anjos/solution-utils"
+	"github.com/bruno-anjos/solution-utils/http_utils"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNeighborIsSelf = errors.New("neighbor is this node")
+	ErrNeighborExists = errors.New("neighbor already exists")
+)
+
+type (
+	// Node is an archimedes node. All its state lives here, so it can be embedded in other binaries and
+	// several nodes can run in the same process.
+	Node struct {
+		id string
+		// incarnation makes the versions of this run newer than the ones of previous runs
+		incarnation int64
+		// address is where the clients of the local services reach this node
+		address string
+		// horizon is how many hops away services registered without a horizon are advertised
+		horizon          int
+		initialNeighbors []string
+
+		config   *Config
+		signer   *Signer
+		encoding payloadEncoding
+
+		messagesReceived *MessagesCache
+		servicesTable    *ServicesTable
+		neighborsTable   *NeighborsTable
+		transport        Transport
+		dissemination    Dissemination
+		tableBroadcaster *TableBroadcaster
+		queryCache       *QueryCache
+		// dht is nil unless enabled in the config
+		dht *DHT
+
+		startOnce sync.Once
+		stopOnce  sync.Once
+		stop      chan struct{}
+	}
+
+	Option func(n *Node)
+)
+
+// WithId sets the id of the node. By default it is the common name in the TLS certificate, if using TLS, or
+// a random one.
+func WithId(id string) Option {
+	return func(n *Node) {
+		n.id = id
+	}
+}
+
+// WithAddress sets the address advertised for the local services
+func WithAddress(address string) Option {
+	return func(n *Node) {
+		n.address = address
+	}
+}
+
+// WithNeighbors sets the addresses of the nodes to add as neighbors when the node starts
+func WithNeighbors(addrs ...string) Option {
+	return func(n *Node) {
+		n.initialNeighbors = append(n.initialNeighbors, addrs...)
+	}
+}
+
+// WithHorizon sets how many hops away services registered without a horizon are advertised
+func WithHorizon(maxHops int) Option {
+	return func(n *Node) {
+		n.horizon = maxHops
+	}
+}
+
+// WithConfig sets the timers and options of the node. Zero values are replaced by the defaults.
+func WithConfig(config *Config) Option {
+	return func(n *Node) {
+		n.config = config
+	}
+}
+
+// WithTransport replaces the HTTP transport used to reach the other nodes
+func WithTransport(transport Transport) Option {
+	return func(n *Node) {
+		n.transport = transport
+	}
+}
+
+// New creates a node. It does nothing until it is started.
+func New(options ...Option) (*Node, error) {
+	n := &Node{
+		id:               "",
+		incarnation:      time.Now().UnixNano(),
+		address:          api.DefaultHostPort,
+		horizon:          defaultMaxHops,
+		initialNeighbors: nil,
+		config:           DefaultConfig(),
+		startOnce:        sync.Once{},
+		stopOnce:         sync.Once{},
+		stop:             make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(n)
+	}
+
+	if n.horizon <= 0 {
+		return nil, errors.New(fmt.Sprintf("invalid horizon %d", n.horizon))
+	}
+
+	n.config = n.config.withDefaults()
+
+	switch n.config.LinkCost {
+	case hopLinkCostName, rttLinkCostName:
+	default:
+		log.Errorf("unknown link cost %s, using %s", n.config.LinkCost, hopLinkCostName)
+		n.config.LinkCost = hopLinkCostName
+	}
+
+	if n.id == "" {
+		// with TLS the node identity is bound to its certificate
+		if n.config.TLSConfig != nil {
+			id, err := idFromTLSConfig(n.config.TLSConfig)
+			if err != nil {
+				return nil, err
+			}
+			n.id = id
+		} else {
+			n.id = uuid.New().String()
+		}
+	}
+
+	var err error
+	n.signer, err = NewSigner(n.config.HMACKeys, n.config.HMACActiveKeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	n.encoding, err = newPayloadEncoding(n.config.Encoding, n.config.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.transport == nil {
+		n.transport = newHTTPTransport(n.config.TLSConfig, n.encoding)
+	}
+
+	n.messagesReceived = NewMessagesCache(n.config.MessagesCacheTTL, n.config.MessagesCacheCapacity)
+	n.servicesTable = NewServicesTable(n.id)
+	n.neighborsTable = NewNeighborsTable()
+	n.dissemination = newDissemination(n)
+	n.tableBroadcaster = NewTableBroadcaster(n.config.BroadcastWindow, n.sendServicesTable)
+	n.queryCache = NewQueryCache(n.config.QueryCacheTTL)
+	if n.config.DHT {
+		n.dht = newDHT(n)
+	}
+
+	log.Infof("ARCHIMEDES ID: %s", n.id)
+
+	return n, nil
+}
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Routes returns the routes the node serves, relative to api.PrefixPath
+func (n *Node) Routes() []http_utils.Route {
+	return n.routes()
+}
+
+// Handler serves the archimedes API under api.PrefixPath
+func (n *Node) Handler() http.Handler {
+	return newRouter(n.routes())
+}
+
+// Start runs the background work of the node until it is stopped and joins the initial neighbors
+func (n *Node) Start() {
+	n.startOnce.Do(func() {
+		go n.monitorNeighbors()
+		go n.antiEntropy()
+		go n.collectTombstones()
+		go n.refreshLocalServices()
+		go n.expireServices()
+		go n.dissemination.Run(n.stop)
+		go n.tableBroadcaster.Run(n.stop)
+		if n.dht != nil {
+			go n.dht.Run(n.stop)
+		}
+
+		for _, addr := range n.initialNeighbors {
+			go n.joinNeighbor(addr)
+		}
+	})
+}
+
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stop)
+	})
+}
+
+// AddNeighbor asks the node at addr who it is and adds it as a neighbor. The link gets the given cost, or the
+// measured or default one if it is not positive.
+func (n *Node) AddNeighbor(addr string, cost float64) (*genericutils.Node, error) {
+	neighborId, err := n.transport.WhoAreYou(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if neighborId == n.id {
+		return nil, ErrNeighborIsSelf
+	}
+
+	neighbor := genericutils.NewNode(neighborId, addr)
+	added := n.neighborsTable.AddNeighbor(neighbor, cost)
+	if !added {
+		return nil, ErrNeighborExists
+	}
+
+	// the new neighbor has not heard any of our previous broadcasts
+	go n.sendServicesTableToNeighbor(neighbor)
+
+	// neighbors are how a node joins the dht
+	if n.dht != nil {
+		go func() {
+			n.dht.AddContact(neighbor)
+			n.dht.Bootstrap()
+		}()
+	}
+
+	return neighbor, nil
+}
+
+// joinNeighbor keeps trying to add the neighbor, since it may not be up yet, until it succeeds or the node
+// stops
+func (n *Node) joinNeighbor(addr string) {
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := n.AddNeighbor(addr, 0)
+		switch err {
+		case nil, ErrNeighborExists:
+			return
+		case ErrNeighborIsSelf:
+			log.Warnf("%s is this node, not adding it as neighbor", addr)
+			return
+		}
+
+		log.Debugf("could not join neighbor at %s: %s", addr, err)
+
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
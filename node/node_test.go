@@ -0,0 +1,614 @@
+package node
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	scheduler "github.com/bruno-anjos/scheduler/api"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+const (
+	testTimeout = 5 * time.Second
+	// testInstancePort is the port port 80/tcp of the test instances is translated to
+	testInstancePort = "30080"
+)
+
+func newTestNetwork(t *testing.T, count int, config *Config) (*Network, []*Node) {
+	t.Helper()
+
+	nw := NewNetwork(time.Millisecond, 0)
+
+	nodes, err := nw.AddNodes(count, config)
+	if err != nil {
+		nw.Stop()
+		t.Fatal(err)
+	}
+
+	err = nw.ConnectLine()
+	if err != nil {
+		nw.Stop()
+		t.Fatal(err)
+	}
+
+	return nw, nodes
+}
+
+// newNonExpiringConfig keeps the entries from expiring during a test, so it is a withdrawal that removes
+// them
+func newNonExpiringConfig() *Config {
+	config := HarnessConfig()
+	config.EntryTTL = time.Minute
+
+	return config
+}
+
+// newSigningConfig makes the nodes sign every message between them
+func newSigningConfig() *Config {
+	config := HarnessConfig()
+	config.HMACKeys = map[string][]byte{"key": []byte("secret")}
+	config.HMACActiveKeyId = "key"
+
+	return config
+}
+
+// registerTestInstance registers an instance of the service in the node hosting it
+func registerTestInstance(t *testing.T, nw *Network, n *Node, serviceId string) {
+	t.Helper()
+
+	instanceDTO := scheduler.InstanceDTO{
+		ServiceName: serviceId,
+		ImageName:   "",
+		PortTranslation: nat.PortMap{
+			"80/tcp": []nat.PortBinding{{HostIP: "", HostPort: testInstancePort}},
+		},
+		EnvVars: nil,
+		Static:  true,
+		Local:   false,
+	}
+
+	status, err := nw.Do(n, http.MethodPost, api.GetServiceInstancePath(serviceId, "instance"), instanceDTO,
+		nil)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("got status %d registering instance of %s: %v", status, serviceId, err)
+	}
+}
+
+func TestHorizonLimitedLine(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 5, nil)
+	defer nw.Stop()
+
+	err := nw.RegisterService(nodes[0], "service", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertKnows(t, testTimeout, "service", nodes[:3]...)
+
+	for i, n := range nodes[:3] {
+		numberOfHops, _ := n.servicesTable.GetServiceNumberOfHops("service")
+		if numberOfHops != i {
+			t.Fatalf("%s has service %d hops away, expected %d", n.id, numberOfHops, i)
+		}
+	}
+
+	// give the service time to go further than it should, including a refresh
+	time.Sleep(2 * HarnessConfig().RefreshInterval)
+
+	for _, n := range nodes[3:] {
+		if Knows(n, "service") {
+			t.Fatalf("%s is beyond the horizon but knows the service", n.id)
+		}
+	}
+}
+
+func TestHorizonShrink(t *testing.T) {
+	horizons := map[string]api.HorizonDTO{
+		"hops": {MaxHops: 1, MaxCost: 0},
+		"cost": {MaxHops: 0, MaxCost: 1},
+	}
+
+	for name, horizon := range horizons {
+		horizon := horizon
+		t.Run(name, func(t *testing.T) {
+			nw, nodes := newTestNetwork(t, 5, newNonExpiringConfig())
+			defer nw.Stop()
+
+			err := nw.RegisterService(nodes[0], "service", 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			AssertKnows(t, testTimeout, "service", nodes...)
+
+			status, err := nw.Do(nodes[0], http.MethodPut, api.GetHorizonPath("service"), horizon, nil)
+			if err != nil || status != http.StatusOK {
+				t.Fatalf("got status %d changing horizon: %v", status, err)
+			}
+
+			AssertNotKnows(t, testTimeout, "service", nodes[2:]...)
+			AssertKnows(t, testTimeout, "service", nodes[:2]...)
+		})
+	}
+}
+
+func TestTombstonePropagation(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 4, nil)
+	defer nw.Stop()
+
+	err := nw.RegisterService(nodes[0], "service", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertKnows(t, testTimeout, "service", nodes...)
+
+	err = nw.DeleteService(nodes[0], "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertNotKnows(t, testTimeout, "service", nodes...)
+
+	for _, n := range nodes[1:] {
+		tombstone, ok := n.servicesTable.GetTombstone("service")
+		if !ok || tombstone.Host != nodes[0].id {
+			t.Fatalf("%s has no tombstone for the service", n.id)
+		}
+	}
+
+	// registering it again has to win over the tombstones
+	err = nw.RegisterService(nodes[0], "service", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertKnows(t, testTimeout, "service", nodes...)
+}
+
+func TestFailureWithdrawal(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 4, newNonExpiringConfig())
+	defer nw.Stop()
+
+	err := nw.RegisterService(nodes[0], "service", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertKnows(t, testTimeout, "service", nodes...)
+
+	nw.Cut(nodes[0], nodes[1])
+
+	AssertNotKnows(t, testTimeout, "service", nodes[1:]...)
+
+	nw.Restore(nodes[0], nodes[1])
+
+	AssertKnows(t, testTimeout, "service", nodes...)
+}
+
+func TestInstancesOnlyAddedByHost(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 2, nil)
+	defer nw.Stop()
+
+	err := nw.RegisterService(nodes[0], "service", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertKnows(t, testTimeout, "service", nodes...)
+
+	status, err := nw.Do(nodes[1], http.MethodPost, api.GetServiceInstancePath("service", "instance"),
+		scheduler.InstanceDTO{}, nil)
+	if err != nil || status != http.StatusNotFound {
+		t.Fatalf("got status %d adding an instance to a service hosted elsewhere: %v", status, err)
+	}
+
+	// give the instance time to reach the host if it was sent
+	time.Sleep(2 * HarnessConfig().RefreshInterval)
+
+	for _, n := range nodes {
+		if instances := n.servicesTable.GetAllServiceInstances("service"); len(instances) != 0 {
+			t.Fatalf("%s has instances %v of a service without any", n.id, instances)
+		}
+	}
+}
+
+func TestDeleteOnlyByHost(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 3, nil)
+	defer nw.Stop()
+
+	err := nw.RegisterService(nodes[0], "service", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registerTestInstance(t, nw, nodes[0], "service")
+
+	AssertKnows(t, testTimeout, "service", nodes...)
+
+	status, err := nw.Do(nodes[1], http.MethodDelete, api.GetServiceInstancePath("service", "instance"), nil, nil)
+	if err != nil || status != http.StatusNotFound {
+		t.Fatalf("got status %d deleting an instance of a service hosted elsewhere: %v", status, err)
+	}
+
+	err = nw.DeleteService(nodes[1], "service")
+	if err == nil {
+		t.Fatal("deleted a service hosted elsewhere")
+	}
+
+	// give the deletions time to spread if they were sent
+	time.Sleep(2 * HarnessConfig().RefreshInterval)
+
+	for _, n := range nodes {
+		if !Knows(n, "service") {
+			t.Fatalf("%s no longer knows the service", n.id)
+		}
+
+		if _, ok := n.servicesTable.GetServiceInstance("service", "instance"); !ok {
+			t.Fatalf("%s no longer has the instance", n.id)
+		}
+	}
+}
+
+func TestLoopedEntriesDropped(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 1, nil)
+	defer nw.Stop()
+
+	newEntry := func(serviceId string, path ...string) *api.ServicesTableEntryDTO {
+		return &api.ServicesTableEntryDTO{
+			Host:         "host",
+			HostAddr:     "10.0.0.1",
+			Service:      &api.Service{Id: serviceId, Ports: nat.PortSet{}},
+			Instances:    map[string]*api.Instance{},
+			NumberOfHops: len(path),
+			MaxHops:      4,
+			Version:      api.NewVersion("host", 1),
+			Cost:         float64(len(path)),
+			MaxCost:      0,
+			Path:         path,
+		}
+	}
+
+	discoverMsg := api.DiscoverMsg{
+		MessageId:    uuid.New(),
+		Origin:       "neighbor",
+		NeighborSent: "neighbor",
+		Entries: map[string]*api.ServicesTableEntryDTO{
+			"service": newEntry("service", "host", "neighbor"),
+			// this one already went through the node
+			"looped": newEntry("looped", "host", nodes[0].id, "neighbor"),
+		},
+		Withdrawn:  nil,
+		Tombstones: nil,
+		KeyId:      "",
+		Signature:  nil,
+	}
+
+	status, err := nw.Do(nodes[0], http.MethodPost, api.GetDiscoverPath(), discoverMsg, nil)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("got status %d sending discover message: %v", status, err)
+	}
+
+	if !Knows(nodes[0], "service") {
+		t.Fatalf("%s ignored an entry that did not loop", nodes[0].id)
+	}
+
+	if Knows(nodes[0], "looped") {
+		t.Fatalf("%s took an entry that already went through it", nodes[0].id)
+	}
+}
+
+func TestResolveBeyondHorizon(t *testing.T) {
+	// queries and their answers are signed too
+	config := newSigningConfig()
+
+	nw, nodes := newTestNetwork(t, 4, config)
+	defer nw.Stop()
+
+	err := nw.RegisterService(nodes[0], "service", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registerTestInstance(t, nw, nodes[0], "service")
+
+	AssertKnows(t, testTimeout, "service", nodes[1])
+
+	if Knows(nodes[3], "service") {
+		t.Fatalf("%s is beyond the horizon but knows the service", nodes[3].id)
+	}
+
+	resolved := api.ResolvedDTO{}
+	status, err := nw.Do(nodes[3], http.MethodPost, api.GetResolvePath(), api.ToResolveDTO{
+		Host: "service",
+		Port: "80/tcp",
+	}, &resolved)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("got status %d resolving the service: %v", status, err)
+	}
+
+	if resolved.Port != testInstancePort || resolved.Hops != 3 {
+		t.Fatalf("resolved to %+v, expected port %s 3 hops away", resolved, testInstancePort)
+	}
+}
+
+func TestResolveDeadline(t *testing.T) {
+	config := HarnessConfig()
+	config.ResolveTimeout = 200 * time.Millisecond
+
+	// without the deadline the expanding ring alone takes 200ms+400ms+600ms+800ms on this network
+	nw := NewNetwork(200*time.Millisecond, 0)
+	defer nw.Stop()
+
+	nodes, err := nw.AddNodes(6, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = nw.ConnectLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	status, err := nw.Do(nodes[0], http.MethodPost, api.GetResolvePath(), api.ToResolveDTO{
+		Host: "missing",
+		Port: "80/tcp",
+	}, nil)
+	elapsed := time.Since(start)
+
+	if err != nil || status != http.StatusNotFound {
+		t.Fatalf("got status %d resolving a missing service: %v", status, err)
+	}
+
+	if elapsed > 3*config.ResolveTimeout {
+		t.Fatalf("resolving a missing service took %s with a deadline of %s", elapsed, config.ResolveTimeout)
+	}
+}
+
+func TestResolveThroughDHT(t *testing.T) {
+	// the queries cannot reach the service, so it has to be found in the dht
+	config := newSigningConfig()
+	config.QueryTTL = 1
+	config.DHT = true
+
+	nw, nodes := newTestNetwork(t, 5, config)
+	defer nw.Stop()
+	nw.ReachAll()
+
+	err := nw.RegisterService(nodes[0], "service", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registerTestInstance(t, nw, nodes[0], "service")
+
+	// only signed store messages and lookups are taken
+	impostor := api.DHTContactDTO{Id: "impostor", Addr: "impostor:50000"}
+	status, err := nw.Do(nodes[4], http.MethodPost, api.GetDHTStorePath(), api.DHTStoreMsg{
+		Sender:    impostor,
+		ServiceId: "service",
+		KeyId:     "",
+		Signature: nil,
+	}, nil)
+	if err != nil || status != http.StatusUnauthorized {
+		t.Fatalf("got status %d storing an unsigned provider: %v", status, err)
+	}
+
+	key := newDHTKey("service")
+	status, err = nw.Do(nodes[4], http.MethodPost, api.GetDHTFindPath(), api.DHTFindMsg{
+		Sender:    impostor,
+		Key:       key[:],
+		FindValue: true,
+		KeyId:     "",
+		Signature: nil,
+	}, nil)
+	if err != nil || status != http.StatusUnauthorized {
+		t.Fatalf("got status %d on an unsigned lookup: %v", status, err)
+	}
+
+	for _, contact := range nodes[4].dht.closestContacts(newDHTKey(impostor.Id), len(nodes)) {
+		if contact.Id == impostor.Id {
+			t.Fatal("unsigned lookup added its sender to the routing table")
+		}
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		resolved := api.ResolvedDTO{}
+		status, err = nw.Do(nodes[4], http.MethodPost, api.GetResolvePath(), api.ToResolveDTO{
+			Host: "service",
+			Port: "80/tcp",
+		}, &resolved)
+		if err == nil && status == http.StatusOK {
+			if resolved.Port != testInstancePort || resolved.Hops != 1 {
+				t.Fatalf("resolved to %+v, expected port %s 1 hop away", resolved,
+					testInstancePort)
+			}
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got status %d resolving the service: %v", status, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestEpidemicFanout(t *testing.T) {
+	// no rounds, refreshes or pulls during the test, so only the push of the change reaches the leaves
+	config := newNonExpiringConfig()
+	config.Dissemination = epidemicDisseminationName
+	config.GossipFanout = 1
+	config.GossipInterval = time.Minute
+	config.AntiEntropyInterval = time.Minute
+	config.RefreshInterval = time.Minute
+
+	nw := NewNetwork(time.Millisecond, 0)
+	defer nw.Stop()
+
+	nodes, err := nw.AddNodes(5, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	center, leaves := nodes[0], nodes[1:]
+	for _, leaf := range leaves {
+		err = nw.Link(center, leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = nw.RegisterService(center, "service", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	knowing := func() (count int) {
+		for _, leaf := range leaves {
+			if Knows(leaf, "service") {
+				count++
+			}
+		}
+		return
+	}
+
+	if !WaitUntil(testTimeout, func() bool { return knowing() > 0 }) {
+		t.Fatal("no leaf learned the service")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if count := knowing(); count != config.GossipFanout {
+		t.Fatalf("%d leaves learned the service, expected fanout %d", count, config.GossipFanout)
+	}
+}
+
+func TestEpidemicConvergence(t *testing.T) {
+	config := HarnessConfig()
+	config.Dissemination = epidemicDisseminationName
+	config.GossipFanout = 1
+
+	nw, nodes := newTestNetwork(t, 5, config)
+	defer nw.Stop()
+
+	err := nw.RegisterService(nodes[0], "service", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertKnows(t, testTimeout, "service", nodes...)
+}
+
+func TestSplitHorizon(t *testing.T) {
+	for _, poisonedReverse := range []bool{false, true} {
+		poisonedReverse := poisonedReverse
+		t.Run(fmt.Sprintf("poisoned reverse %t", poisonedReverse), func(t *testing.T) {
+			config := HarnessConfig()
+			config.PoisonedReverse = poisonedReverse
+
+			nw, nodes := newTestNetwork(t, 2, config)
+			defer nw.Stop()
+
+			lock := sync.Mutex{}
+			var advertised, poisoned bool
+			nw.Observe(func(from, to string, discoverMsg *api.DiscoverMsg) {
+				if from != nw.Addr(nodes[1]) || to != nw.Addr(nodes[0]) {
+					return
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+
+				if _, ok := discoverMsg.Entries["service"]; ok {
+					advertised = true
+				}
+
+				for _, serviceId := range discoverMsg.Withdrawn {
+					if serviceId == "service" {
+						poisoned = true
+					}
+				}
+			})
+
+			// the horizon lets the node that learned the service advertise it further
+			err := nw.RegisterService(nodes[0], "service", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			AssertKnows(t, testTimeout, "service", nodes[1])
+
+			// a change in the table of the node that learned the service makes it send the table to the host
+			err = nw.RegisterService(nodes[1], "other", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			AssertKnows(t, testTimeout, "other", nodes[0])
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if advertised {
+				t.Fatalf("%s advertised the service back to its host", nodes[1].id)
+			}
+
+			if poisoned != poisonedReverse {
+				t.Fatalf("%s advertised the service as withdrawn %t, expected %t", nodes[1].id, poisoned,
+					poisonedReverse)
+			}
+		})
+	}
+}
+
+func TestCheapestPath(t *testing.T) {
+	nw := NewNetwork(time.Millisecond, 0)
+	defer nw.Stop()
+
+	nodes, err := nw.AddNodes(3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the direct link from a to c costs more than going through b
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	for _, link := range []struct {
+		from, to *Node
+		cost     float64
+	}{{a, b, 1}, {b, c, 1}, {a, c, 10}} {
+		err = nw.LinkWithCost(link.from, link.to, link.cost)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = nw.RegisterService(a, "service", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	throughB := func() bool {
+		entry, ok := c.servicesTable.GetServiceEntry("service")
+		return ok && entry.Cost == 2 && entry.NumberOfHops == 2
+	}
+
+	if !WaitUntil(testTimeout, throughB) {
+		entry, _ := c.servicesTable.GetServiceEntry("service")
+		t.Fatalf("%s did not take the cheapest path, got %+v", c.id, entry)
+	}
+
+	// the refreshes that keep coming through the direct link must not replace it
+	time.Sleep(2 * HarnessConfig().RefreshInterval)
+
+	if !throughB() {
+		entry, _ := c.servicesTable.GetServiceEntry("service")
+		t.Fatalf("%s went back to a more expensive path, got %+v", c.id, entry)
+	}
+}
@@ -0,0 +1,199 @@
+package node
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	genericutils "github.com/bruno-anjos/solution-utils"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultQueryTTL = 4
+	// defaultResolveTimeout is below the 10s timeout of api.ResolveServiceInArchimedes, so it gets a 404 for
+	// the hosts that are not services instead of timing out
+	defaultResolveTimeout = 5 * time.Second
+)
+
+type (
+	queryAnswer struct {
+		entry *api.ServicesTableEntryDTO
+		found bool
+	}
+)
+
+// queryService looks for a service missing from the table with an expanding ring search, i.e. querying the
+// nodes 1 hop away, then 2, then 4, up to QueryTTL, so the closest instances are found without querying
+// farther than needed. What it finds is cached. It gives up when ctx is done.
+func (n *Node) queryService(ctx context.Context, serviceId string) (entry *api.ServicesTableEntryDTO, ok bool) {
+	entry, ok = n.queryCache.Get(serviceId)
+	if ok {
+		return entry, true
+	}
+
+	for radius := 1; ; radius *= 2 {
+		if radius > n.config.QueryTTL {
+			radius = n.config.QueryTTL
+		}
+
+		// every ring is a new query, otherwise the nodes already queried would ignore it
+		queryMsg := &api.QueryMsg{
+			QueryId:      uuid.New(),
+			Origin:       n.id,
+			NeighborSent: n.id,
+			ServiceId:    serviceId,
+			TTL:          radius,
+		}
+
+		n.messagesReceived.Add(queryMsg.QueryId)
+
+		entry, ok = n.queryNeighbors(ctx, queryMsg, nil)
+		if ok {
+			log.Debugf("found service %s hosted by %s %d hops away", serviceId, entry.Host, entry.NumberOfHops)
+			n.queryCache.Add(serviceId, entry)
+			return entry, true
+		}
+
+		log.Debugf("no node within %d hops has instances of service %s", radius, serviceId)
+
+		if radius == n.config.QueryTTL || ctx.Err() != nil {
+			return nil, false
+		}
+	}
+}
+
+// queryNeighbors sends the query to every neighbor not in exclude at once and returns the first entry found,
+// or nothing if ctx is done first. The queries still going on when it returns are canceled.
+func (n *Node) queryNeighbors(ctx context.Context, queryMsg *api.QueryMsg, exclude map[string]struct{}) (
+	entry *api.ServicesTableEntryDTO, ok bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	answers := make(chan queryAnswer)
+	pending := 0
+
+	for neighborId, neighbor := range n.neighborsTable.GetAvailableNeighbors() {
+		if _, excluded := exclude[neighborId]; excluded {
+			continue
+		}
+
+		pending++
+		go func(neighbor *genericutils.Node) {
+			answers <- n.queryNeighbor(ctx, neighbor, queryMsg)
+		}(neighbor)
+	}
+
+	for ; pending > 0; pending-- {
+		select {
+		case answer := <-answers:
+			if answer.found {
+				// the other answers are not waited for
+				go drainQueryAnswers(answers, pending-1)
+				return answer.entry, true
+			}
+		case <-ctx.Done():
+			log.Debugf("gave up on query %s: %s", queryMsg.QueryId, ctx.Err())
+			go drainQueryAnswers(answers, pending)
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// queryNeighbor only takes answers signed by the neighbor for this query, since they end up cached and
+// served to the queries of other nodes
+func (n *Node) queryNeighbor(ctx context.Context, neighbor *genericutils.Node, queryMsg *api.QueryMsg) queryAnswer {
+	signedMsg, err := n.signer.SignQuery(queryMsg)
+	if err != nil {
+		log.Error(err)
+		return queryAnswer{}
+	}
+
+	answer, found, err := n.transport.Query(ctx, neighbor.Addr, signedMsg)
+	if err != nil {
+		log.Warnf("failed querying %s for service %s: %s", neighbor.Id, queryMsg.ServiceId, err)
+		return queryAnswer{}
+	}
+
+	if !found {
+		return queryAnswer{}
+	}
+
+	err = n.signer.Verify(answer)
+	if err != nil {
+		log.Warnf("rejecting answer from %s to query %s: %s", neighbor.Id, queryMsg.QueryId, err)
+		return queryAnswer{}
+	}
+
+	entry, ok := answer.Entries[queryMsg.ServiceId]
+	if !ok || entry == nil || answer.MessageId != queryMsg.QueryId || answer.NeighborSent != neighbor.Id {
+		log.Warnf("rejecting answer from %s that does not match query %s", neighbor.Id, queryMsg.QueryId)
+		return queryAnswer{}
+	}
+
+	if entry.Host == neighbor.Id {
+		remoteAddr, _, err := net.SplitHostPort(neighbor.Addr)
+		if err != nil {
+			remoteAddr = neighbor.Addr
+		}
+		fillHostAddr(remoteAddr, entry)
+	}
+
+	// the answer is one hop further away for this node
+	entry.NumberOfHops++
+	entry.Cost += n.neighborsTable.GetLinkCost(neighbor.Id)
+
+	return queryAnswer{
+		entry: entry,
+		found: true,
+	}
+}
+
+func drainQueryAnswers(answers <-chan queryAnswer, pending int) {
+	for ; pending > 0; pending-- {
+		<-answers
+	}
+}
+
+// lookupServiceInDHT finds the hosts of the service in the DHT and asks them for their entry directly. They
+// are not reached through the neighbors, so their entry counts as one hop away. A provider that answers with
+// the entry of another host is ignored, since anyone with the keys can announce itself as a provider.
+func (n *Node) lookupServiceInDHT(ctx context.Context, serviceId string) (entry *api.ServicesTableEntryDTO,
+	ok bool) {
+	for _, provider := range n.dht.FindProviders(ctx, serviceId) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		queryMsg := &api.QueryMsg{
+			QueryId:      uuid.New(),
+			Origin:       n.id,
+			NeighborSent: n.id,
+			ServiceId:    serviceId,
+			TTL:          1,
+		}
+
+		n.messagesReceived.Add(queryMsg.QueryId)
+
+		answer := n.queryNeighbor(ctx, provider, queryMsg)
+		if answer.found && answer.entry.Host != provider.Id {
+			log.Warnf("rejecting answer from %s for service %s hosted by %s", provider.Id, serviceId,
+				answer.entry.Host)
+			continue
+		}
+
+		if answer.found {
+			log.Debugf("found service %s in the dht, hosted by %s", serviceId, provider.Id)
+			n.queryCache.Add(serviceId, answer.entry)
+			return answer.entry, true
+		}
+	}
+
+	log.Debugf("service %s is not in the dht", serviceId)
+
+	return nil, false
+}
@@ -0,0 +1,62 @@
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultQueryCacheTTL = 30 * time.Second
+)
+
+type (
+	queryCacheEntry struct {
+		Entry    *api.ServicesTableEntryDTO
+		CachedAt time.Time
+	}
+
+	// QueryCache keeps the entries found by querying other nodes for a while, so resolving a service beyond
+	// the horizon does not send a query every time. The entries are not in the services table, so they are
+	// never advertised.
+	QueryCache struct {
+		ttl      time.Duration
+		cacheMap sync.Map
+	}
+
+	typeQueryCacheMapKey   = string
+	typeQueryCacheMapValue = *queryCacheEntry
+)
+
+func NewQueryCache(ttl time.Duration) *QueryCache {
+	return &QueryCache{
+		ttl:      ttl,
+		cacheMap: sync.Map{},
+	}
+}
+
+func (qc *QueryCache) Add(serviceId string, entry *api.ServicesTableEntryDTO) {
+	qc.cacheMap.Store(serviceId, &queryCacheEntry{
+		Entry:    entry,
+		CachedAt: time.Now(),
+	})
+}
+
+// Get returns the cached entry for the service, unless it expired
+func (qc *QueryCache) Get(serviceId string) (entry *api.ServicesTableEntryDTO, ok bool) {
+	value, ok := qc.cacheMap.Load(serviceId)
+	if !ok {
+		return nil, false
+	}
+
+	cached := value.(typeQueryCacheMapValue)
+	if time.Since(cached.CachedAt) > qc.ttl {
+		log.Debugf("cached query answer for service %s expired", serviceId)
+		qc.cacheMap.Delete(serviceId)
+		return nil, false
+	}
+
+	return cached.Entry, true
+}
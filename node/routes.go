@@ -0,0 +1,225 @@
+package node
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/bruno-anjos/archimedes/api"
+	"github.com/bruno-anjos/solution-utils/http_utils"
+)
+
+// Route names
+const (
+	registerServiceName                  = "REGISTER_SERVICE"
+	deleteServiceName                    = "DELETE_SERVICE"
+	registerServiceInstanceName          = "REGISTER_SERVICE_INSTANCE"
+	deleteServiceInstanceName            = "DELETE_SERVICE_INSTANCE"
+	getAllServicesName                   = "GET_ALL_SERVICES"
+	getAllServiceInstancesName           = "GET_ALL_SERVICE_INSTANCES"
+	getServiceInstanceName               = "GET_SERVICE_INSTANCE"
+	getInstanceName                      = "GET_INSTANCE"
+	changeInstanceStateName              = "CHANGE_INSTANCE_STATE"
+	discoverName                         = "DISCOVER"
+	whoAreYouName                        = "WHO_ARE_YOU"
+	getTableName                         = "GET_TABLE"
+	syncTableName                        = "SYNC_TABLE"
+	resolveName                          = "RESOLVE"
+	addNeighborName                      = "ADD_NEIGHBOR"
+	getAllNeighborsName                  = "GET_ALL_NEIGHBORS"
+	deleteNeighborName                   = "DELETE_NEIGHBOR"
+	getMessagesCacheStatsName            = "GET_MESSAGES_CACHE_STATS"
+	changeServiceHorizonName             = "CHANGE_SERVICE_HORIZON"
+	queryName                            = "QUERY"
+	dhtFindName                          = "DHT_FIND"
+	dhtStoreName                         = "DHT_STORE"
+)
+
+// Path variables
+const (
+	ServiceIdPathVar  = "serviceId"
+	InstanceIdPathVar = "instanceId"
+	NeighborIdPathVar = "neighborId"
+)
+
+var (
+	_serviceIdPathVarFormatted  = fmt.Sprintf(http_utils.PathVarFormat, ServiceIdPathVar)
+	_instanceIdPathVarFormatted = fmt.Sprintf(http_utils.PathVarFormat, InstanceIdPathVar)
+	_neighborIdPathVarFormatted = fmt.Sprintf(http_utils.PathVarFormat, NeighborIdPathVar)
+
+	servicesRoute        = api.ServicesPath
+	serviceRoute         = fmt.Sprintf(api.ServicePath, _serviceIdPathVarFormatted)
+	serviceInstanceRoute = fmt.Sprintf(api.ServiceInstancePath, _serviceIdPathVarFormatted,
+		_instanceIdPathVarFormatted)
+	instanceRoute  = fmt.Sprintf(api.InstancePath, _instanceIdPathVarFormatted)
+	discoverRoute  = api.DiscoverPath
+	whoAreYouRoute = api.WhoAreYouPath
+	tableRoute     = api.TablePath
+	resolveRoute   = api.ResolvePath
+	neighborsRoute = api.NeighborsPath
+	neighborRoute  = fmt.Sprintf(api.NeighborPath, _neighborIdPathVarFormatted)
+
+	messagesCacheStatsRoute = api.MessagesCacheStatsPath
+	horizonRoute            = fmt.Sprintf(api.HorizonPath, _serviceIdPathVarFormatted)
+	queryRoute              = api.QueryPath
+	dhtFindRoute            = api.DHTFindPath
+	dhtStoreRoute           = api.DHTStorePath
+)
+
+func (n *Node) routes() []http_utils.Route {
+	return []http_utils.Route{
+		{
+			Name:        changeInstanceStateName,
+			Method:      http.MethodPut,
+			Pattern:     serviceInstanceRoute,
+			QueryParams: []string{api.StatusQueryVar, fmt.Sprintf(http_utils.PathVarFormat, api.StatusQueryVar)},
+			HandlerFunc: n.changeInstanceStateHandler,
+		},
+
+		{
+			Name:        registerServiceName,
+			Method:      http.MethodPost,
+			Pattern:     serviceRoute,
+			HandlerFunc: n.registerServiceHandler,
+		},
+
+		{
+			Name:        deleteServiceName,
+			Method:      http.MethodDelete,
+			Pattern:     serviceRoute,
+			HandlerFunc: n.deleteServiceHandler,
+		},
+
+		{
+			Name:        registerServiceInstanceName,
+			Method:      http.MethodPost,
+			Pattern:     serviceInstanceRoute,
+			HandlerFunc: n.registerServiceInstanceHandler,
+		},
+
+		{
+			Name:        deleteServiceInstanceName,
+			Method:      http.MethodDelete,
+			Pattern:     serviceInstanceRoute,
+			HandlerFunc: n.deleteServiceInstanceHandler,
+		},
+
+		{
+			Name:        getAllServicesName,
+			Method:      http.MethodGet,
+			Pattern:     servicesRoute,
+			HandlerFunc: n.getAllServicesHandler,
+		},
+
+		{
+			Name:        getAllServiceInstancesName,
+			Method:      http.MethodGet,
+			Pattern:     serviceRoute,
+			HandlerFunc: n.getAllServiceInstancesHandler,
+		},
+
+		{
+			Name:        getInstanceName,
+			Method:      http.MethodGet,
+			Pattern:     instanceRoute,
+			HandlerFunc: n.getInstanceHandler,
+		},
+
+		{
+			Name:        getServiceInstanceName,
+			Method:      http.MethodGet,
+			Pattern:     serviceInstanceRoute,
+			HandlerFunc: n.getServiceInstanceHandler,
+		},
+
+		{
+			Name:        discoverName,
+			Method:      http.MethodPost,
+			Pattern:     discoverRoute,
+			HandlerFunc: n.discoverHandler,
+		},
+
+		{
+			Name:        whoAreYouName,
+			Method:      http.MethodGet,
+			Pattern:     whoAreYouRoute,
+			HandlerFunc: n.whoAreYouHandler,
+		},
+
+		{
+			Name:        getTableName,
+			Method:      http.MethodGet,
+			Pattern:     tableRoute,
+			HandlerFunc: n.getServicesTableHandler,
+		},
+
+		{
+			Name:        syncTableName,
+			Method:      http.MethodPost,
+			Pattern:     tableRoute,
+			HandlerFunc: n.syncTableHandler,
+		},
+
+		{
+			Name:        resolveName,
+			Method:      http.MethodPost,
+			Pattern:     resolveRoute,
+			HandlerFunc: n.resolveHandler,
+		},
+
+		{
+			Name:        addNeighborName,
+			Method:      http.MethodPost,
+			Pattern:     neighborsRoute,
+			HandlerFunc: n.addNeighborHandler,
+		},
+
+		{
+			Name:        getAllNeighborsName,
+			Method:      http.MethodGet,
+			Pattern:     neighborsRoute,
+			HandlerFunc: n.getAllNeighborsHandler,
+		},
+
+		{
+			Name:        deleteNeighborName,
+			Method:      http.MethodDelete,
+			Pattern:     neighborRoute,
+			HandlerFunc: n.deleteNeighborHandler,
+		},
+
+		{
+			Name:        getMessagesCacheStatsName,
+			Method:      http.MethodGet,
+			Pattern:     messagesCacheStatsRoute,
+			HandlerFunc: n.getMessagesCacheStatsHandler,
+		},
+
+		{
+			Name:        changeServiceHorizonName,
+			Method:      http.MethodPut,
+			Pattern:     horizonRoute,
+			HandlerFunc: n.changeServiceHorizonHandler,
+		},
+
+		{
+			Name:        queryName,
+			Method:      http.MethodPost,
+			Pattern:     queryRoute,
+			HandlerFunc: n.queryHandler,
+		},
+
+		{
+			Name:        dhtFindName,
+			Method:      http.MethodPost,
+			Pattern:     dhtFindRoute,
+			HandlerFunc: n.dhtFindHandler,
+		},
+
+		{
+			Name:        dhtStoreName,
+			Method:      http.MethodPost,
+			Pattern:     dhtStoreRoute,
+			HandlerFunc: n.dhtStoreHandler,
+		},
+	}
+}
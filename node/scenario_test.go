@@ -0,0 +1,336 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	genericutils "github.com/bruno-anjos/solution-utils"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	// PartitionScenario splits a network in two, lets each side change its services while they cannot talk,
+	// heals the network and measures how the tables converge
+	PartitionScenario struct {
+		SideA []*Node
+		SideB []*Node
+		// Duration is how long the partition lasts. If it is longer than the failure timeout the nodes on each
+		// side withdraw the services of the other one.
+		Duration time.Duration
+		// DuringPartition runs right after the network is split, to register or delete services on each side
+		DuringPartition func(nw *Network) error
+		// Timeout is how long the nodes have to converge after the partition heals
+		Timeout time.Duration
+	}
+
+	ScenarioReport struct {
+		Converged bool
+		// ConvergenceTime is how long the nodes took to converge after the partition healed
+		ConvergenceTime time.Duration
+		// Inconsistencies holds the services the nodes still disagreed on when the scenario ended
+		Inconsistencies []*Inconsistency
+	}
+
+	// Inconsistency is a service the nodes disagree on, with the entry each node has for it, or nil if it has
+	// none
+	Inconsistency struct {
+		ServiceId string
+		Entries   map[string]*api.DigestEntryDTO
+	}
+)
+
+// RunPartitionScenario runs the scenario on the network. The error is only set if the scenario could not be
+// run, not converging is reported in the ScenarioReport.
+func (nw *Network) RunPartitionScenario(scenario *PartitionScenario) (*ScenarioReport, error) {
+	if len(scenario.SideA) == 0 || len(scenario.SideB) == 0 {
+		return nil, errors.New("both sides of the partition need nodes")
+	}
+
+	nodes := make([]*Node, 0, len(scenario.SideA)+len(scenario.SideB))
+	nodes = append(nodes, scenario.SideA...)
+	nodes = append(nodes, scenario.SideB...)
+
+	nw.Partition(scenario.SideA, scenario.SideB)
+
+	if scenario.DuringPartition != nil {
+		err := scenario.DuringPartition(nw)
+		if err != nil {
+			nw.Heal()
+			return nil, err
+		}
+	}
+
+	time.Sleep(scenario.Duration)
+
+	nw.Heal()
+	healed := time.Now()
+
+	report := &ScenarioReport{
+		Converged:       false,
+		ConvergenceTime: 0,
+		Inconsistencies: nil,
+	}
+
+	report.Converged = WaitUntil(scenario.Timeout, func() bool { return Converged(nodes...) })
+	if report.Converged {
+		report.ConvergenceTime = time.Since(healed)
+	}
+
+	report.Inconsistencies = Inconsistencies(nodes...)
+
+	log.Infof("partition scenario: %s", report)
+
+	return report, nil
+}
+
+func (r *ScenarioReport) String() string {
+	if r.Converged {
+		return fmt.Sprintf("converged %s after healing", r.ConvergenceTime)
+	}
+
+	inconsistencies := make([]string, len(r.Inconsistencies))
+	for i, inconsistency := range r.Inconsistencies {
+		inconsistencies[i] = inconsistency.String()
+	}
+
+	return fmt.Sprintf("did not converge, inconsistent entries: %s", strings.Join(inconsistencies, "; "))
+}
+
+func (i *Inconsistency) String() string {
+	nodeIds := make([]string, 0, len(i.Entries))
+	for nodeId := range i.Entries {
+		nodeIds = append(nodeIds, nodeId)
+	}
+
+	sort.Strings(nodeIds)
+
+	views := make([]string, len(nodeIds))
+	for j, nodeId := range nodeIds {
+		entry := i.Entries[nodeId]
+		if entry == nil {
+			views[j] = fmt.Sprintf("%s: missing", nodeId)
+		} else {
+			views[j] = fmt.Sprintf("%s: %s@%s", nodeId, entry.Host, entry.Version)
+		}
+	}
+
+	return fmt.Sprintf("%s (%s)", i.ServiceId, strings.Join(views, ", "))
+}
+
+// Inconsistencies returns the services some node does not have the expected entry for, sorted by id. Nodes
+// within the horizon of a service must have the entry of its host and the others must not have any. If the
+// host is not one of the nodes, every node must have the same entry.
+func Inconsistencies(nodes ...*Node) []*Inconsistency {
+	digests := make(map[string]map[string]*api.DigestEntryDTO, len(nodes))
+	serviceIds := map[string]struct{}{}
+	for _, n := range nodes {
+		digest := n.servicesTable.ToDigest()
+		digests[n.id] = digest
+		for serviceId := range digest {
+			serviceIds[serviceId] = struct{}{}
+		}
+	}
+
+	var inconsistencies []*Inconsistency
+	for serviceId := range serviceIds {
+		expected := expectedEntries(serviceId, digests, nodes)
+
+		entries := make(map[string]*api.DigestEntryDTO, len(nodes))
+		consistent := true
+		for _, n := range nodes {
+			entry := digests[n.id][serviceId]
+			entries[n.id] = entry
+
+			if !sameDigestEntry(expected[n.id], entry) {
+				consistent = false
+			}
+		}
+
+		if !consistent {
+			inconsistencies = append(inconsistencies, &Inconsistency{
+				ServiceId: serviceId,
+				Entries:   entries,
+			})
+		}
+	}
+
+	sort.Slice(inconsistencies, func(i, j int) bool {
+		return inconsistencies[i].ServiceId < inconsistencies[j].ServiceId
+	})
+
+	return inconsistencies
+}
+
+// expectedEntries returns the entry each node should have for the service, nil for the nodes that should not
+// have one
+func expectedEntries(serviceId string, digests map[string]map[string]*api.DigestEntryDTO,
+	nodes []*Node) map[string]*api.DigestEntryDTO {
+	expected := make(map[string]*api.DigestEntryDTO, len(nodes))
+
+	for _, n := range nodes {
+		entry := digests[n.id][serviceId]
+		if entry == nil || entry.Host != n.id {
+			continue
+		}
+
+		hostEntry, ok := n.servicesTable.GetServiceEntry(serviceId)
+		if !ok {
+			break
+		}
+
+		for nodeId := range withinHorizon(n, hostEntry, nodes) {
+			expected[nodeId] = entry
+		}
+
+		return expected
+	}
+
+	for _, n := range nodes {
+		expected[n.id] = digests[nodes[0].id][serviceId]
+	}
+
+	return expected
+}
+
+type pathLength struct {
+	hops int
+	cost float64
+}
+
+// withinHorizon returns the nodes the entry of the host reaches, taking the nodes as the whole network and
+// their neighbors as its links, even the ones declared dead, since those are expected to come back. As the
+// nodes do, each one keeps the cheapest path it hears of and only passes it on while it is within the
+// horizon.
+func withinHorizon(host *Node, hostEntry *api.ServicesTableEntryDTO, nodes []*Node) map[string]pathLength {
+	links := make(map[string]map[string]*genericutils.Node, len(nodes))
+	for _, n := range nodes {
+		links[n.id] = n.neighborsTable.GetAllNeighbors()
+	}
+
+	reached := map[string]pathLength{
+		host.id: {hops: 0, cost: 0},
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, n := range nodes {
+			if n == host {
+				continue
+			}
+
+			for neighborId := range links[n.id] {
+				from, ok := reached[neighborId]
+				if !ok || from.hops+1 > hostEntry.MaxHops {
+					continue
+				}
+
+				// both nodes have to be neighbors of each other for the link to be used
+				if _, ok = links[neighborId][n.id]; !ok {
+					continue
+				}
+
+				path := pathLength{
+					hops: from.hops + 1,
+					cost: from.cost + n.neighborsTable.GetLinkCost(neighborId),
+				}
+				if hostEntry.MaxCost > 0 && path.cost > hostEntry.MaxCost {
+					continue
+				}
+
+				current, ok := reached[n.id]
+				if ok && (current.cost < path.cost || (current.cost == path.cost && current.hops <= path.hops)) {
+					continue
+				}
+
+				reached[n.id] = path
+				changed = true
+			}
+		}
+	}
+
+	return reached
+}
+
+func sameDigestEntry(a, b *api.DigestEntryDTO) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Host == b.Host && a.Version == b.Version
+}
+
+func TestConvergedWithinHorizon(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 4, nil)
+	defer nw.Stop()
+
+	err := nw.RegisterService(nodes[0], "service", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last node is beyond the default horizon, which is not an inconsistency
+	AssertConverged(t, testTimeout, nodes...)
+
+	if Knows(nodes[3], "service") {
+		t.Fatalf("%s is beyond the horizon but knows the service", nodes[3].id)
+	}
+
+	// but having the entry beyond the horizon is
+	hostEntry, _ := nodes[0].servicesTable.GetServiceEntry("service")
+	hostEntry.NumberOfHops = defaultMaxHops + 1
+	nodes[3].servicesTable.AddService("service", nodes[2].id, hostEntry)
+
+	inconsistencies := Inconsistencies(nodes...)
+	if len(inconsistencies) != 1 || inconsistencies[0].ServiceId != "service" {
+		t.Fatalf("expected the entry beyond the horizon to be reported, got %v", inconsistencies)
+	}
+}
+
+func TestPartitionScenario(t *testing.T) {
+	nw, nodes := newTestNetwork(t, 6, nil)
+	defer nw.Stop()
+
+	for _, serviceId := range []string{"kept", "deleted"} {
+		err := nw.RegisterService(nodes[5], serviceId, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	AssertConverged(t, testTimeout, nodes...)
+
+	report, err := nw.RunPartitionScenario(&PartitionScenario{
+		SideA: nodes[:3],
+		SideB: nodes[3:],
+		// long enough for the sides to withdraw the services of each other
+		Duration: 2 * HarnessConfig().FailureTimeout,
+		DuringPartition: func(nw *Network) error {
+			err := nw.RegisterService(nodes[0], "added", 5)
+			if err != nil {
+				return err
+			}
+
+			return nw.DeleteService(nodes[5], "deleted")
+		},
+		Timeout: testTimeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Converged || len(report.Inconsistencies) > 0 {
+		t.Fatalf("partition scenario failed: %s", report)
+	}
+
+	for _, n := range nodes {
+		if !Knows(n, "kept") || !Knows(n, "added") || Knows(n, "deleted") {
+			t.Fatalf("%s knows %v after the partition healed", n.id, KnownServices(n))
+		}
+	}
+}
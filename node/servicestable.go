@@ -0,0 +1,946 @@
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	genericutils "github.com/bruno-anjos/solution-utils"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	ServicesTableEntry struct {
+		Host         *genericutils.Node
+		Service      *api.Service
+		Instances    *sync.Map
+		NumberOfHops int
+		MaxHops      int
+		Version      api.Version
+		// Cost is the sum of the costs of the links between this node and the host
+		Cost    float64
+		MaxCost float64
+		// NextHop is the neighbor the entry was learned from, or this node for local services
+		NextHop string
+		// Path is the path the entry was learned through, empty for local services
+		Path []string
+		// LastRefreshed is the last time the host was heard advertising the entry. Remote entries that are not
+		// refreshed expire.
+		LastRefreshed time.Time
+		EntryLock     *sync.RWMutex
+	}
+)
+
+func NewTempServiceTableEntry() *ServicesTableEntry {
+	return &ServicesTableEntry{
+		Host:          nil,
+		Service:       nil,
+		Instances:     nil,
+		NumberOfHops:  0,
+		MaxHops:       0,
+		Version:       api.Version{},
+		Cost:          0,
+		MaxCost:       0,
+		NextHop:       "",
+		Path:          nil,
+		LastRefreshed: time.Time{},
+		EntryLock:     &sync.RWMutex{},
+	}
+}
+
+func (se *ServicesTableEntry) ToDTO() *api.ServicesTableEntryDTO {
+	instances := map[string]*api.Instance{}
+
+	se.EntryLock.RLock()
+	defer se.EntryLock.RUnlock()
+
+	se.Instances.Range(func(key, value interface{}) bool {
+		instanceId := key.(typeInstancesMapKey)
+		instance := value.(typeInstancesMapValue)
+
+		instanceCopy := *instance
+		instanceCopy.Local = false
+		instances[instanceId] = &instanceCopy
+
+		return true
+	})
+
+	return &api.ServicesTableEntryDTO{
+		Host:         se.Host.Id,
+		HostAddr:     se.Host.Addr,
+		Service:      se.Service,
+		Instances:    instances,
+		NumberOfHops: se.NumberOfHops,
+		MaxHops:      se.MaxHops,
+		Version:      se.Version,
+		Cost:         se.Cost,
+		MaxCost:      se.MaxCost,
+		Path:         append([]string(nil), se.Path...),
+	}
+}
+
+type (
+	tombstoneEntry struct {
+		Tombstone *api.TombstoneDTO
+		CreatedAt time.Time
+	}
+)
+
+type (
+	ServicesTable struct {
+		// archimedesId is the id of the node the table belongs to
+		archimedesId         string
+		addLock              sync.Mutex
+		servicesMap          sync.Map
+		instancesMap         sync.Map
+		neighborsServicesMap sync.Map
+		tombstonesMap        sync.Map
+	}
+
+	typeServicesTableMapKey   = string
+	typeServicesTableMapValue = *ServicesTableEntry
+
+	typeInstancesMapKey   = string
+	typeInstancesMapValue = *api.Instance
+
+	typeNeighborsServicesMapKey   = string
+	typeNeighborsServicesMapValue = *sync.Map
+
+	typeTombstonesMapKey   = string
+	typeTombstonesMapValue = *tombstoneEntry
+)
+
+func NewServicesTable(archimedesId string) *ServicesTable {
+	return &ServicesTable{
+		archimedesId:         archimedesId,
+		addLock:              sync.Mutex{},
+		servicesMap:          sync.Map{},
+		instancesMap:         sync.Map{},
+		neighborsServicesMap: sync.Map{},
+		tombstonesMap:        sync.Map{},
+	}
+}
+
+// UpdateService replaces the entry if the new one is fresher or, being the same version, was learned through
+// a cheaper path. Nothing is updated if the entry is gone, since it may be expired or withdrawn at any time.
+func (st *ServicesTable) UpdateService(serviceId, nextHop string, newEntry *api.ServicesTableEntryDTO) bool {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		log.Debugf("service %s was deleted before being updated", serviceId)
+		return false
+	}
+
+	entry := value.(typeServicesTableMapValue)
+
+	// the comparison and the write happen under the same lock, otherwise an older entry delivered at the
+	// same time could overwrite a newer one
+	entry.EntryLock.Lock()
+	defer entry.EntryLock.Unlock()
+
+	log.Debugf("got service on version %s with cost %.2f, have %s with cost %.2f", newEntry.Version,
+		newEntry.Cost, entry.Version, entry.Cost)
+
+	// ignore messages with no new information
+	cmp := newEntry.Version.Compare(entry.Version)
+	if cmp < 0 || (cmp == 0 && !isCheaperPath(newEntry, entry)) {
+		log.Debug("discarding message due to version being older or equal with a path not cheaper")
+		return false
+	}
+
+	// message is fresher or comes through a cheaper path
+	entry.Host = genericutils.NewNode(newEntry.Host, newEntry.HostAddr)
+	entry.Service = newEntry.Service
+
+	entry.Instances.Range(func(key, value interface{}) bool {
+		instanceId := key.(typeInstancesMapKey)
+		_, ok = newEntry.Instances[instanceId]
+		if !ok {
+			st.instancesMap.Delete(instanceId)
+		}
+
+		return true
+	})
+
+	newInstancesMap := &sync.Map{}
+	for instanceId, instance := range newEntry.Instances {
+		newInstancesMap.Store(instanceId, instance)
+		st.instancesMap.Store(instanceId, instance)
+	}
+
+	entry.Instances = newInstancesMap
+	entry.NumberOfHops = newEntry.NumberOfHops
+	entry.Version = newEntry.Version
+	entry.MaxHops = newEntry.MaxHops
+	entry.Cost = newEntry.Cost
+	entry.MaxCost = newEntry.MaxCost
+	entry.NextHop = nextHop
+	entry.Path = append([]string(nil), newEntry.Path...)
+	entry.LastRefreshed = time.Now()
+
+	log.Debugf("updated service %s table entry to: %+v", serviceId, entry)
+	log.Debugf("with instances %+v", newEntry.Instances)
+
+	return true
+}
+
+// isCheaperPath compares the costs of the paths to the host, using the number of hops to break ties
+func isCheaperPath(newEntry *api.ServicesTableEntryDTO, entry *ServicesTableEntry) bool {
+	if newEntry.Cost != entry.Cost {
+		return newEntry.Cost < entry.Cost
+	}
+
+	return newEntry.NumberOfHops < entry.NumberOfHops
+}
+
+func (st *ServicesTable) AddService(serviceId, nextHop string, newEntry *api.ServicesTableEntryDTO) (added bool) {
+	_, ok := st.servicesMap.Load(serviceId)
+	if ok {
+		added = false
+		return
+	}
+
+	st.addLock.Lock()
+	_, ok = st.servicesMap.Load(serviceId)
+	if ok {
+		st.addLock.Unlock()
+		added = false
+		return
+	}
+
+	newTableEntry := NewTempServiceTableEntry()
+	newTableEntry.EntryLock.Lock()
+	defer newTableEntry.EntryLock.Unlock()
+	st.servicesMap.Store(serviceId, newTableEntry)
+	st.addLock.Unlock()
+
+	newTableEntry.Host = genericutils.NewNode(newEntry.Host, newEntry.HostAddr)
+	newTableEntry.Service = newEntry.Service
+
+	newInstancesMap := &sync.Map{}
+	for instanceId, instance := range newEntry.Instances {
+		newInstancesMap.Store(instanceId, instance)
+		st.instancesMap.Store(instanceId, instance)
+	}
+
+	newTableEntry.Instances = newInstancesMap
+	newTableEntry.NumberOfHops = newEntry.NumberOfHops
+	newTableEntry.Version = newEntry.Version
+	newTableEntry.MaxHops = newEntry.MaxHops
+	newTableEntry.Cost = newEntry.Cost
+	newTableEntry.MaxCost = newEntry.MaxCost
+	newTableEntry.NextHop = nextHop
+	newTableEntry.Path = append([]string(nil), newEntry.Path...)
+	newTableEntry.LastRefreshed = time.Now()
+
+	added = true
+
+	log.Debugf("added new table entry for service %s: %+v", serviceId, newTableEntry)
+	log.Debugf("with instances %+v", newEntry.Instances)
+
+	return
+}
+
+func (st *ServicesTable) GetService(serviceId string) (service *api.Service, ok bool) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return nil, false
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.RLock()
+	defer entry.EntryLock.RUnlock()
+
+	return entry.Service, true
+}
+
+func (st *ServicesTable) GetServiceEntry(serviceId string) (entry *api.ServicesTableEntryDTO, ok bool) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(typeServicesTableMapValue).ToDTO(), true
+}
+
+func (st *ServicesTable) GetServiceNumberOfHops(serviceId string) (numberOfHops int, ok bool) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return 0, false
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.RLock()
+	defer entry.EntryLock.RUnlock()
+
+	return entry.NumberOfHops, true
+}
+
+func (st *ServicesTable) GetAllServices() map[string]*api.Service {
+	services := map[string]*api.Service{}
+
+	st.servicesMap.Range(func(key, value interface{}) bool {
+		serviceId := key.(string)
+		entry := value.(typeServicesTableMapValue)
+		entry.EntryLock.RLock()
+		defer entry.EntryLock.RUnlock()
+
+		services[serviceId] = entry.Service
+
+		return true
+	})
+
+	return services
+}
+
+func (st *ServicesTable) GetAllServiceInstances(serviceId string) map[string]*api.Instance {
+	instances := map[string]*api.Instance{}
+
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return instances
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.RLock()
+	defer entry.EntryLock.RUnlock()
+
+	entry.Instances.Range(func(key, value interface{}) bool {
+		instanceId := key.(string)
+		instance := value.(typeInstancesMapValue)
+
+		instances[instanceId] = instance
+
+		return true
+	})
+
+	return instances
+}
+
+// AddInstance adds an instance to a service hosted by this node and bumps its version, so the new set of
+// instances reaches the nodes that learned about the service
+func (st *ServicesTable) AddInstance(serviceId, instanceId string, instance *api.Instance) (added bool) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		added = false
+		return
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.Lock()
+	defer entry.EntryLock.Unlock()
+
+	// only the host versions the entry
+	if entry.Host.Id != st.archimedesId {
+		added = false
+		return
+	}
+
+	entry.Instances.Store(instanceId, instance)
+	entry.Version = entry.Version.Next()
+
+	st.instancesMap.Store(instanceId, instance)
+
+	added = true
+	return
+}
+
+func (st *ServicesTable) IsLocalService(serviceId string) bool {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return false
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.RLock()
+	defer entry.EntryLock.RUnlock()
+
+	return entry.Host.Id == st.archimedesId
+}
+
+// GetLocalServices returns the ids of the services registered in this node
+func (st *ServicesTable) GetLocalServices() (serviceIds []string) {
+	st.servicesMap.Range(func(key, value interface{}) bool {
+		serviceId := key.(typeServicesTableMapKey)
+		entry := value.(typeServicesTableMapValue)
+
+		entry.EntryLock.RLock()
+		defer entry.EntryLock.RUnlock()
+
+		if entry.Host.Id == st.archimedesId {
+			serviceIds = append(serviceIds, serviceId)
+		}
+
+		return true
+	})
+
+	return
+}
+
+// SetServiceHorizon changes how far the service is advertised. The version is bumped so the nodes that
+// already know the service learn the new horizon.
+func (st *ServicesTable) SetServiceHorizon(serviceId string, maxHops int, maxCost float64) (ok bool) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return false
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.Lock()
+	defer entry.EntryLock.Unlock()
+
+	entry.MaxHops = maxHops
+	entry.MaxCost = maxCost
+	entry.Version = entry.Version.Next()
+
+	return true
+}
+
+func (st *ServicesTable) ServiceHasInstance(serviceId, instanceId string) bool {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return false
+	}
+
+	entry := value.(typeServicesTableMapValue)
+
+	entry.EntryLock.RLock()
+	defer entry.EntryLock.RUnlock()
+
+	_, ok = entry.Instances.Load(instanceId)
+
+	return ok
+}
+
+func (st *ServicesTable) GetServiceInstance(serviceId, instanceId string) (*api.Instance, bool) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return nil, false
+	}
+
+	entry := value.(typeServicesTableMapValue)
+
+	entry.EntryLock.RLock()
+	defer entry.EntryLock.RUnlock()
+
+	value, ok = entry.Instances.Load(instanceId)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(typeInstancesMapValue), ok
+}
+
+func (st *ServicesTable) GetInstance(instanceId string) (instance *api.Instance, ok bool) {
+	value, ok := st.instancesMap.Load(instanceId)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(typeInstancesMapValue), true
+}
+
+func (st *ServicesTable) DeleteService(serviceId string) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.RLock()
+	entry.Instances.Range(func(key, _ interface{}) bool {
+		instanceId := key.(typeInstancesMapKey)
+		st.instancesMap.Delete(instanceId)
+		return true
+	})
+	entry.EntryLock.RUnlock()
+
+	st.servicesMap.Delete(serviceId)
+
+	st.neighborsServicesMap.Range(func(_, value interface{}) bool {
+		value.(typeNeighborsServicesMapValue).Delete(serviceId)
+		return true
+	})
+}
+
+// DeleteServiceWithTombstone deletes a service hosted by this node and keeps a tombstone for it, so the
+// deletion can be sent to the nodes that learned about the service
+func (st *ServicesTable) DeleteServiceWithTombstone(serviceId string) (tombstone *api.TombstoneDTO, ok bool) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return nil, false
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.RLock()
+	if entry.Host.Id != st.archimedesId {
+		entry.EntryLock.RUnlock()
+		return nil, false
+	}
+
+	tombstone = &api.TombstoneDTO{
+		Host:         entry.Host.Id,
+		Version:      entry.Version.Next(),
+		NumberOfHops: 0,
+		MaxHops:      entry.MaxHops,
+	}
+	entry.EntryLock.RUnlock()
+
+	st.DeleteService(serviceId)
+	st.storeTombstone(serviceId, tombstone)
+
+	log.Debugf("deleted service %s with tombstone %+v", serviceId, tombstone)
+
+	return tombstone, true
+}
+
+// DeleteInstance deletes an instance of a service hosted by this node and bumps the service version, so the
+// new set of instances replaces the old one in the nodes that learned about the service
+func (st *ServicesTable) DeleteInstance(serviceId, instanceId string) (deleted bool) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return false
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.Lock()
+	defer entry.EntryLock.Unlock()
+
+	// only the host versions the entry
+	if entry.Host.Id != st.archimedesId {
+		return false
+	}
+
+	entry.Instances.Delete(instanceId)
+	entry.Version = entry.Version.Next()
+
+	st.instancesMap.Delete(instanceId)
+
+	return true
+}
+
+// ApplyTombstones deletes the entries older than the tombstones received and stores the tombstones. It
+// returns the tombstones that were new to this node.
+func (st *ServicesTable) ApplyTombstones(tombstones map[string]*api.TombstoneDTO) (applied map[string]*api.TombstoneDTO) {
+	applied = map[string]*api.TombstoneDTO{}
+
+	for serviceId, tombstone := range tombstones {
+		// this node is the authority on its own services
+		if tombstone.Host == st.archimedesId {
+			continue
+		}
+
+		value, ok := st.tombstonesMap.Load(serviceId)
+		if ok {
+			existing := value.(typeTombstonesMapValue).Tombstone
+			if existing.Host == tombstone.Host && existing.Version.Compare(tombstone.Version) >= 0 {
+				continue
+			}
+		}
+
+		value, ok = st.servicesMap.Load(serviceId)
+		if ok {
+			entry := value.(typeServicesTableMapValue)
+			entry.EntryLock.RLock()
+			host, version := entry.Host.Id, entry.Version
+			entry.EntryLock.RUnlock()
+
+			if host == tombstone.Host {
+				if version.Compare(tombstone.Version) >= 0 {
+					log.Debugf("ignoring tombstone for service %s since entry is newer", serviceId)
+					continue
+				}
+
+				log.Debugf("deleting service %s due to tombstone %+v", serviceId, tombstone)
+				st.DeleteService(serviceId)
+			}
+		}
+
+		st.storeTombstone(serviceId, tombstone)
+		applied[serviceId] = tombstone
+	}
+
+	return
+}
+
+func (st *ServicesTable) GetTombstone(serviceId string) (tombstone *api.TombstoneDTO, ok bool) {
+	value, ok := st.tombstonesMap.Load(serviceId)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(typeTombstonesMapValue).Tombstone, true
+}
+
+func (st *ServicesTable) DeleteTombstone(serviceId string) {
+	st.tombstonesMap.Delete(serviceId)
+}
+
+// CollectTombstones deletes the tombstones older than the grace period
+func (st *ServicesTable) CollectTombstones(gracePeriod time.Duration) (collected int) {
+	st.tombstonesMap.Range(func(key, value interface{}) bool {
+		serviceId := key.(typeTombstonesMapKey)
+		entry := value.(typeTombstonesMapValue)
+
+		if time.Since(entry.CreatedAt) > gracePeriod {
+			log.Debugf("collecting tombstone for service %s", serviceId)
+			st.tombstonesMap.Delete(serviceId)
+			collected++
+		}
+
+		return true
+	})
+
+	return
+}
+
+func (st *ServicesTable) storeTombstone(serviceId string, tombstone *api.TombstoneDTO) {
+	tombstoneCopy := *tombstone
+	st.tombstonesMap.Store(serviceId, &tombstoneEntry{
+		Tombstone: &tombstoneCopy,
+		CreatedAt: time.Now(),
+	})
+}
+
+// isDeleted checks if the entry is older than a tombstone for the service. A newer entry means the service
+// was created again, so the tombstone is no longer needed.
+func (st *ServicesTable) isDeleted(serviceId string, entry *api.ServicesTableEntryDTO) bool {
+	value, ok := st.tombstonesMap.Load(serviceId)
+	if !ok {
+		return false
+	}
+
+	tombstone := value.(typeTombstonesMapValue).Tombstone
+	if tombstone.Host != entry.Host {
+		return false
+	}
+
+	if entry.Version.Compare(tombstone.Version) < 0 {
+		return true
+	}
+
+	st.tombstonesMap.Delete(serviceId)
+
+	return false
+}
+
+// UpdateTableWithDiscoverMessage merges the entries of the message into the table. It also returns the
+// services whose horizon shrank so that they are no longer advertised past this node, which the nodes that
+// learned them from this node under the old horizon have to withdraw.
+func (st *ServicesTable) UpdateTableWithDiscoverMessage(neighbor string,
+	discoverMsg *api.DiscoverMsg) (changed bool, beyondHorizon []string) {
+	log.Debugf("updating table from message %s", discoverMsg.MessageId.String())
+
+	changed = false
+
+	for serviceId, entry := range discoverMsg.Entries {
+		log.Debugf("%s has service %s", neighbor, serviceId)
+
+		if entry.Host == st.archimedesId {
+			continue
+		}
+
+		if st.isDeleted(serviceId, entry) {
+			log.Debugf("service %s was deleted, ignoring entry", serviceId)
+			continue
+		}
+
+		value, ok := st.servicesMap.Load(serviceId)
+		if ok {
+			existing := value.(typeServicesTableMapValue)
+			existing.EntryLock.RLock()
+			isLocal := existing.Host.Id == st.archimedesId
+			wasAdvertised := existing.NumberOfHops+1 <= existing.MaxHops
+			existing.EntryLock.RUnlock()
+
+			// a service registered in this node is not replaced by another host advertising the same id
+			if isLocal {
+				log.Debugf("%s also hosts service %s, keeping local entry", entry.Host, serviceId)
+				continue
+			}
+
+			log.Debugf("service %s already existed, updating", serviceId)
+			updated := st.UpdateService(serviceId, neighbor, entry)
+			if updated {
+				st.addNeighborService(neighbor, serviceId)
+				changed = true
+
+				if wasAdvertised && entry.NumberOfHops+1 > entry.MaxHops {
+					log.Debugf("service %s horizon no longer goes past this node", serviceId)
+					beyondHorizon = append(beyondHorizon, serviceId)
+				}
+			} else {
+				st.refreshService(serviceId, neighbor, entry)
+			}
+			continue
+		}
+
+		added := st.AddService(serviceId, neighbor, entry)
+		if added {
+			st.addNeighborService(neighbor, serviceId)
+			changed = true
+		}
+	}
+
+	return changed, beyondHorizon
+}
+
+// refreshService marks the entry as refreshed if the host re-advertised the version we have. If it came from
+// the neighbor the entry was learned from, the cost of that path is also updated, since it may have grown.
+func (st *ServicesTable) refreshService(serviceId, neighbor string, advertised *api.ServicesTableEntryDTO) {
+	value, ok := st.servicesMap.Load(serviceId)
+	if !ok {
+		return
+	}
+
+	entry := value.(typeServicesTableMapValue)
+	entry.EntryLock.Lock()
+	defer entry.EntryLock.Unlock()
+
+	if entry.Host.Id == advertised.Host && entry.Version.Compare(advertised.Version) == 0 {
+		entry.LastRefreshed = time.Now()
+		if entry.NextHop == neighbor {
+			entry.Cost = advertised.Cost
+		}
+	}
+}
+
+// ExpireServices deletes the remote entries that were not refreshed within ttl and returns their ids
+func (st *ServicesTable) ExpireServices(ttl time.Duration) (expired []string) {
+	st.servicesMap.Range(func(key, value interface{}) bool {
+		serviceId := key.(typeServicesTableMapKey)
+		entry := value.(typeServicesTableMapValue)
+
+		entry.EntryLock.RLock()
+		isLocal := entry.Host.Id == st.archimedesId
+		lastRefreshed := entry.LastRefreshed
+		entry.EntryLock.RUnlock()
+
+		if !isLocal && time.Since(lastRefreshed) > ttl {
+			log.Debugf("service %s expired, last refreshed at %s", serviceId, lastRefreshed)
+			st.DeleteService(serviceId)
+			expired = append(expired, serviceId)
+		}
+
+		return true
+	})
+
+	return
+}
+
+// ToRefreshMsg builds a discover message with every local service, used to refresh them in the other nodes
+func (st *ServicesTable) ToRefreshMsg(archimedesId string) *api.DiscoverMsg {
+	discoverMsg := st.ToDiscoverMsg(archimedesId)
+	if discoverMsg == nil {
+		return nil
+	}
+
+	for serviceId, entry := range discoverMsg.Entries {
+		if entry.Host != archimedesId {
+			delete(discoverMsg.Entries, serviceId)
+		}
+	}
+
+	if len(discoverMsg.Entries) == 0 {
+		return nil
+	}
+
+	return discoverMsg
+}
+
+func (st *ServicesTable) ToDiscoverMsg(archimedesId string) *api.DiscoverMsg {
+	discoverMsg, _ := st.ToDeltaDiscoverMsg(archimedesId, nil)
+	return discoverMsg
+}
+
+// ToDeltaDiscoverMsg builds a discover message with the entries whose version differs from the one in
+// sentVersions. If sentVersions is nil every entry is included. It also returns the versions of all the
+// entries that can be sent, which is what the receiver knows once it gets the message.
+func (st *ServicesTable) ToDeltaDiscoverMsg(archimedesId string,
+	sentVersions map[string]api.Version) (discoverMsg *api.DiscoverMsg, versions map[string]api.Version) {
+	entries := map[string]*api.ServicesTableEntryDTO{}
+	versions = map[string]api.Version{}
+
+	st.servicesMap.Range(func(key, value interface{}) bool {
+		serviceId := key.(typeServicesTableMapKey)
+		entry := value.(typeServicesTableMapValue)
+
+		entry.EntryLock.RLock()
+		numberOfHops, maxHops := entry.NumberOfHops, entry.MaxHops
+		entry.EntryLock.RUnlock()
+
+		if numberOfHops+1 > maxHops {
+			return true
+		}
+
+		entryDTO := entry.ToDTO()
+		versions[serviceId] = entryDTO.Version
+
+		if sentVersions != nil {
+			sentVersion, ok := sentVersions[serviceId]
+			if ok && sentVersion == entryDTO.Version {
+				return true
+			}
+		}
+
+		entryDTO.NumberOfHops++
+		entryDTO.Path = append(entryDTO.Path, archimedesId)
+		entries[serviceId] = entryDTO
+
+		return true
+	})
+
+	if len(entries) == 0 {
+		return nil, versions
+	}
+
+	discoverMsg = &api.DiscoverMsg{
+		MessageId:    uuid.New(),
+		Origin:       archimedesId,
+		NeighborSent: archimedesId,
+		Entries:      entries,
+	}
+
+	return discoverMsg, versions
+}
+
+func (st *ServicesTable) ToDigest() map[string]*api.DigestEntryDTO {
+	digest := map[string]*api.DigestEntryDTO{}
+
+	st.servicesMap.Range(func(key, value interface{}) bool {
+		serviceId := key.(typeServicesTableMapKey)
+		entry := value.(typeServicesTableMapValue)
+
+		entry.EntryLock.RLock()
+		defer entry.EntryLock.RUnlock()
+
+		digest[serviceId] = &api.DigestEntryDTO{
+			Host:    entry.Host.Id,
+			Version: entry.Version,
+		}
+
+		return true
+	})
+
+	return digest
+}
+
+// DiffFromDigest builds a discover message with the entries the digest sender is missing or has an older
+// version of
+func (st *ServicesTable) DiffFromDigest(archimedesId string, digest *api.TableDigestDTO) *api.DiscoverMsg {
+	discoverMsg := st.ToDiscoverMsg(archimedesId)
+	if discoverMsg == nil {
+		discoverMsg = &api.DiscoverMsg{
+			MessageId:    uuid.New(),
+			Origin:       archimedesId,
+			NeighborSent: archimedesId,
+			Entries:      map[string]*api.ServicesTableEntryDTO{},
+		}
+	}
+
+	discoverMsg.Tombstones = map[string]*api.TombstoneDTO{}
+	st.tombstonesMap.Range(func(key, value interface{}) bool {
+		serviceId := key.(typeTombstonesMapKey)
+		tombstone := value.(typeTombstonesMapValue).Tombstone
+
+		digestEntry, ok := digest.Entries[serviceId]
+		if ok && digestEntry.Host == tombstone.Host &&
+			digestEntry.Version.Compare(tombstone.Version) < 0 {
+			tombstoneCopy := *tombstone
+			tombstoneCopy.NumberOfHops = 1
+			discoverMsg.Tombstones[serviceId] = &tombstoneCopy
+		}
+
+		return true
+	})
+
+	for serviceId, entry := range discoverMsg.Entries {
+		if entry.Host == digest.Sender {
+			delete(discoverMsg.Entries, serviceId)
+			continue
+		}
+
+		digestEntry, ok := digest.Entries[serviceId]
+		if ok && digestEntry.Host == entry.Host && digestEntry.Version.Compare(entry.Version) >= 0 {
+			delete(discoverMsg.Entries, serviceId)
+		}
+	}
+
+	return discoverMsg
+}
+
+func (st *ServicesTable) DeleteNeighborServices(neighborId string) (deleted []string) {
+	value, ok := st.neighborsServicesMap.Load(neighborId)
+	if !ok {
+		return
+	}
+
+	services := value.(typeNeighborsServicesMapValue)
+	services.Range(func(key, _ interface{}) bool {
+		serviceId := key.(typeNeighborsServicesMapKey)
+		log.Debugf("deleting service %s learned from %s", serviceId, neighborId)
+		st.DeleteService(serviceId)
+		deleted = append(deleted, serviceId)
+		return true
+	})
+
+	st.neighborsServicesMap.Delete(neighborId)
+
+	return
+}
+
+// WithdrawNeighborServices deletes the services neighborId withdrew, as long as they were learned from it.
+// It returns the services that were actually deleted.
+func (st *ServicesTable) WithdrawNeighborServices(neighborId string, serviceIds []string) (withdrawn []string) {
+	value, ok := st.neighborsServicesMap.Load(neighborId)
+	if !ok {
+		return
+	}
+
+	services := value.(typeNeighborsServicesMapValue)
+	for _, serviceId := range serviceIds {
+		_, ok = services.Load(serviceId)
+		if !ok {
+			continue
+		}
+
+		log.Debugf("%s withdrew service %s", neighborId, serviceId)
+		services.Delete(serviceId)
+		st.DeleteService(serviceId)
+		withdrawn = append(withdrawn, serviceId)
+	}
+
+	return
+}
+
+// GetNeighborServices returns the services whose entry was learned from the neighbor
+func (st *ServicesTable) GetNeighborServices(neighborId string) map[string]struct{} {
+	services := map[string]struct{}{}
+
+	value, ok := st.neighborsServicesMap.Load(neighborId)
+	if !ok {
+		return services
+	}
+
+	value.(typeNeighborsServicesMapValue).Range(func(key, _ interface{}) bool {
+		services[key.(typeNeighborsServicesMapKey)] = struct{}{}
+		return true
+	})
+
+	return services
+}
+
+// addNeighborService records that the entry for serviceId was learned from neighborId, replacing the
+// neighbor it was previously learned from
+func (st *ServicesTable) addNeighborService(neighborId, serviceId string) {
+	st.neighborsServicesMap.Range(func(key, value interface{}) bool {
+		if key.(typeNeighborsServicesMapKey) != neighborId {
+			value.(typeNeighborsServicesMapValue).Delete(serviceId)
+		}
+		return true
+	})
+
+	value, _ := st.neighborsServicesMap.LoadOrStore(neighborId, &sync.Map{})
+	services := value.(typeNeighborsServicesMapValue)
+	services.Store(serviceId, struct{}{})
+}
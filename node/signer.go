@@ -0,0 +1,269 @@
+package node
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bruno-anjos/archimedes/api"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errUnsignedMessage  = errors.New("message is not signed")
+	errInvalidSignature = errors.New("invalid message signature")
+)
+
+// Signer signs and verifies the messages between nodes with HMAC-SHA256 over a shared key. Several keys can be
+// configured so they can be rotated: messages are signed with the active key and verified with the key
+// they name.
+type Signer struct {
+	keys        map[string][]byte
+	activeKeyId string
+}
+
+// NewSigner signs with the key activeKeyId and verifies with any of the keys. If there are no keys messages
+// are neither signed nor verified.
+func NewSigner(keys map[string][]byte, activeKeyId string) (*Signer, error) {
+	signer := &Signer{
+		keys:        map[string][]byte{},
+		activeKeyId: activeKeyId,
+	}
+
+	if len(keys) == 0 {
+		log.Warn("no keys configured, discover messages will not be signed")
+		signer.activeKeyId = ""
+		return signer, nil
+	}
+
+	for keyId, key := range keys {
+		if len(key) == 0 {
+			return nil, errors.New(fmt.Sprintf("key %s is empty", keyId))
+		}
+		signer.keys[keyId] = key
+	}
+
+	if _, ok := signer.keys[activeKeyId]; !ok {
+		return nil, errors.New(fmt.Sprintf("active key %s is not one of the configured keys", activeKeyId))
+	}
+
+	log.Infof("signing discover messages with key %s", signer.activeKeyId)
+
+	return signer, nil
+}
+
+// hmacKeysFromEnv reads the keys as a comma separated list of keyId:base64Key pairs. The active key is the
+// first one, unless another is set.
+func hmacKeysFromEnv() (keys map[string][]byte, activeKeyId string) {
+	keys = map[string][]byte{}
+
+	keysValue, ok := os.LookupEnv(hmacKeysEnvVar)
+	if !ok || keysValue == "" {
+		return keys, ""
+	}
+
+	for _, keyPair := range strings.Split(keysValue, ",") {
+		splitPair := strings.SplitN(strings.TrimSpace(keyPair), ":", 2)
+		if len(splitPair) != 2 {
+			log.Fatalf("invalid key %s in %s, expected keyId:base64Key", keyPair, hmacKeysEnvVar)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(splitPair[1])
+		if err != nil || len(key) == 0 {
+			log.Fatalf("invalid key %s in %s: %s", splitPair[0], hmacKeysEnvVar, err)
+		}
+
+		keys[splitPair[0]] = key
+		if activeKeyId == "" {
+			activeKeyId = splitPair[0]
+		}
+	}
+
+	envActiveKeyId, ok := os.LookupEnv(hmacActiveKeyIdEnvVar)
+	if ok {
+		activeKeyId = envActiveKeyId
+	}
+
+	return keys, activeKeyId
+}
+
+func (s *Signer) Enabled() bool {
+	return len(s.keys) > 0
+}
+
+// Sign returns a signed copy of the message, so a message being sent to several neighbors is not changed
+func (s *Signer) Sign(discoverMsg *api.DiscoverMsg) (*api.DiscoverMsg, error) {
+	if !s.Enabled() {
+		return discoverMsg, nil
+	}
+
+	signedMsg := *discoverMsg
+
+	err := s.sign(&signedMsg, &signedMsg.KeyId, &signedMsg.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedMsg, nil
+}
+
+func (s *Signer) Verify(discoverMsg *api.DiscoverMsg) error {
+	unsignedMsg := *discoverMsg
+
+	return s.verify(&unsignedMsg, unsignedMsg.KeyId, &unsignedMsg.Signature)
+}
+
+// SignDigest returns a signed copy of the digest, so only the nodes with the keys can pull the table
+func (s *Signer) SignDigest(digest *api.TableDigestDTO) (*api.TableDigestDTO, error) {
+	if !s.Enabled() {
+		return digest, nil
+	}
+
+	signedDigest := *digest
+
+	err := s.sign(&signedDigest, &signedDigest.KeyId, &signedDigest.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedDigest, nil
+}
+
+func (s *Signer) VerifyDigest(digest *api.TableDigestDTO) error {
+	unsignedDigest := *digest
+
+	return s.verify(&unsignedDigest, unsignedDigest.KeyId, &unsignedDigest.Signature)
+}
+
+// SignQuery returns a signed copy of the query, which is signed again by every node that forwards it
+func (s *Signer) SignQuery(queryMsg *api.QueryMsg) (*api.QueryMsg, error) {
+	if !s.Enabled() {
+		return queryMsg, nil
+	}
+
+	signedMsg := *queryMsg
+
+	err := s.sign(&signedMsg, &signedMsg.KeyId, &signedMsg.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedMsg, nil
+}
+
+func (s *Signer) VerifyQuery(queryMsg *api.QueryMsg) error {
+	unsignedMsg := *queryMsg
+
+	return s.verify(&unsignedMsg, unsignedMsg.KeyId, &unsignedMsg.Signature)
+}
+
+// SignDHTFind returns a signed copy of the lookup, so only the nodes with the keys get into the routing
+// tables of the others
+func (s *Signer) SignDHTFind(findMsg *api.DHTFindMsg) (*api.DHTFindMsg, error) {
+	if !s.Enabled() {
+		return findMsg, nil
+	}
+
+	signedMsg := *findMsg
+	err := s.sign(&signedMsg, &signedMsg.KeyId, &signedMsg.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedMsg, nil
+}
+
+func (s *Signer) VerifyDHTFind(findMsg *api.DHTFindMsg) error {
+	unsignedMsg := *findMsg
+
+	return s.verify(&unsignedMsg, unsignedMsg.KeyId, &unsignedMsg.Signature)
+}
+
+// SignDHTStore returns a signed copy of the store message, so only the nodes with the keys can announce
+// themselves as providers
+func (s *Signer) SignDHTStore(storeMsg *api.DHTStoreMsg) (*api.DHTStoreMsg, error) {
+	if !s.Enabled() {
+		return storeMsg, nil
+	}
+
+	signedMsg := *storeMsg
+
+	err := s.sign(&signedMsg, &signedMsg.KeyId, &signedMsg.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedMsg, nil
+}
+
+func (s *Signer) VerifyDHTStore(storeMsg *api.DHTStoreMsg) error {
+	unsignedMsg := *storeMsg
+
+	return s.verify(&unsignedMsg, unsignedMsg.KeyId, &unsignedMsg.Signature)
+}
+
+// sign signs msg with the active key. keyId and signature point to the fields of msg that hold them, which
+// every signed message has.
+func (s *Signer) sign(msg interface{}, keyId *string, signature *[]byte) error {
+	*keyId = s.activeKeyId
+	*signature = nil
+
+	computed, err := s.computeSignature(s.keys[s.activeKeyId], msg)
+	if err != nil {
+		return err
+	}
+
+	*signature = computed
+
+	return nil
+}
+
+// verify checks the signature of msg, which signature points to, against the key with keyId. The
+// signature is cleared from msg, so it must be a copy.
+func (s *Signer) verify(msg interface{}, keyId string, signature *[]byte) error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	received := *signature
+	*signature = nil
+
+	if keyId == "" || len(received) == 0 {
+		return errUnsignedMessage
+	}
+
+	key, ok := s.keys[keyId]
+	if !ok {
+		return errors.New(fmt.Sprintf("unknown key %s", keyId))
+	}
+
+	expected, err := s.computeSignature(key, msg)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(expected, received) {
+		return errInvalidSignature
+	}
+
+	return nil
+}
+
+// computeSignature signs the JSON encoding of the message without the signature, which is canonical since
+// struct fields are encoded in order and map keys are sorted
+func (s *Signer) computeSignature(key []byte, unsignedMsg interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(unsignedMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(encoded)
+
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,284 @@
+package node
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bruno-anjos/archimedes/api"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func newTestSigner(t *testing.T, activeKeyId string, keyIds ...string) *Signer {
+	t.Helper()
+
+	keys := map[string][]byte{}
+	for _, keyId := range keyIds {
+		keys[keyId] = []byte("secret-" + keyId)
+	}
+
+	signer, err := NewSigner(keys, activeKeyId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+func newTestDiscoverMsg() *api.DiscoverMsg {
+	version := api.NewVersion("node-0", 1)
+
+	return &api.DiscoverMsg{
+		MessageId:    uuid.New(),
+		Origin:       "node-0",
+		NeighborSent: "node-1",
+		Entries: map[string]*api.ServicesTableEntryDTO{
+			"service": {
+				Host:     "node-0",
+				HostAddr: "10.0.0.1",
+				Service: &api.Service{
+					Id:    "service",
+					Ports: nat.PortSet{"80/tcp": {}},
+				},
+				Instances: map[string]*api.Instance{
+					"instance": {
+						Id:        "instance",
+						ServiceId: "service",
+						Ip:        "10.0.0.1",
+						PortTranslation: nat.PortMap{
+							"80/tcp": []nat.PortBinding{{HostIP: "", HostPort: "30080"}},
+						},
+						Initialized: true,
+						Static:      false,
+						Local:       false,
+					},
+				},
+				NumberOfHops: 1,
+				MaxHops:      2,
+				Version:      version,
+				Cost:         1.5,
+				MaxCost:      0,
+				Path:         []string{"node-0"},
+			},
+		},
+		Withdrawn: []string{"withdrawn"},
+		Tombstones: map[string]*api.TombstoneDTO{
+			"deleted": {
+				Host:         "node-2",
+				Version:      version.Next(),
+				NumberOfHops: 1,
+				MaxHops:      2,
+			},
+		},
+	}
+}
+
+func TestSignerSignVerify(t *testing.T) {
+	signer := newTestSigner(t, "a", "a")
+
+	discoverMsg := newTestDiscoverMsg()
+	signedMsg, err := signer.Sign(discoverMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if discoverMsg.KeyId != "" || discoverMsg.Signature != nil {
+		t.Fatal("signing changed the original message")
+	}
+
+	err = signer.Verify(signedMsg)
+	if err != nil {
+		t.Fatalf("valid signature rejected: %s", err)
+	}
+
+	signedMsg.Entries["service"].HostAddr = "10.0.0.2"
+	err = signer.Verify(signedMsg)
+	if err != errInvalidSignature {
+		t.Fatalf("expected %s for a tampered message, got %v", errInvalidSignature, err)
+	}
+
+	err = signer.Verify(discoverMsg)
+	if err != errUnsignedMessage {
+		t.Fatalf("expected %s for an unsigned message, got %v", errUnsignedMessage, err)
+	}
+}
+
+func TestSignerRejectsUnknownKey(t *testing.T) {
+	signedMsg, err := newTestSigner(t, "a", "a").Sign(newTestDiscoverMsg())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = newTestSigner(t, "b", "b").Verify(signedMsg)
+	if err == nil {
+		t.Fatal("message signed with an unknown key was accepted")
+	}
+}
+
+func TestSignerKeyRotation(t *testing.T) {
+	signedMsg, err := newTestSigner(t, "old", "old").Sign(newTestDiscoverMsg())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = newTestSigner(t, "new", "old", "new").Verify(signedMsg)
+	if err != nil {
+		t.Fatalf("message signed with a previous key rejected: %s", err)
+	}
+}
+
+func TestSignerDisabled(t *testing.T) {
+	signer := newTestSigner(t, "")
+
+	discoverMsg := newTestDiscoverMsg()
+	signedMsg, err := signer.Sign(discoverMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if signedMsg.Signature != nil {
+		t.Fatal("signer without keys signed the message")
+	}
+
+	err = signer.Verify(signedMsg)
+	if err != nil {
+		t.Fatalf("signer without keys rejected a message: %s", err)
+	}
+}
+
+func TestSignerDigest(t *testing.T) {
+	signer := newTestSigner(t, "a", "a")
+
+	digest := &api.TableDigestDTO{
+		Sender: "node-1",
+		Entries: map[string]*api.DigestEntryDTO{
+			"service": {
+				Host:    "node-0",
+				Version: api.NewVersion("node-0", 1),
+			},
+		},
+	}
+
+	err := signer.VerifyDigest(digest)
+	if err != errUnsignedMessage {
+		t.Fatalf("expected %s for an unsigned digest, got %v", errUnsignedMessage, err)
+	}
+
+	signedDigest, err := signer.SignDigest(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = signer.VerifyDigest(signedDigest)
+	if err != nil {
+		t.Fatalf("valid digest signature rejected: %s", err)
+	}
+
+	signedDigest.Sender = "node-2"
+	err = signer.VerifyDigest(signedDigest)
+	if err != errInvalidSignature {
+		t.Fatalf("expected %s for a tampered digest, got %v", errInvalidSignature, err)
+	}
+}
+
+func TestSignerDHTFind(t *testing.T) {
+	signer := newTestSigner(t, "a", "a")
+
+	findMsg := &api.DHTFindMsg{
+		Sender:    api.DHTContactDTO{Id: "node-0", Addr: "10.0.0.1:50000"},
+		Key:       []byte("key"),
+		FindValue: true,
+		KeyId:     "",
+		Signature: nil,
+	}
+
+	err := signer.VerifyDHTFind(findMsg)
+	if err != errUnsignedMessage {
+		t.Fatalf("expected %s for an unsigned lookup, got %v", errUnsignedMessage, err)
+	}
+
+	signedMsg, err := signer.SignDHTFind(findMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = signer.VerifyDHTFind(signedMsg)
+	if err != nil {
+		t.Fatalf("valid lookup signature rejected: %s", err)
+	}
+
+	signedMsg.Sender.Id = "node-1"
+	err = signer.VerifyDHTFind(signedMsg)
+	if err != errInvalidSignature {
+		t.Fatalf("expected %s for a tampered lookup, got %v", errInvalidSignature, err)
+	}
+}
+
+func TestSignerDHTStore(t *testing.T) {
+	signer := newTestSigner(t, "a", "a")
+
+	storeMsg := &api.DHTStoreMsg{
+		Sender:    api.DHTContactDTO{Id: "node-0", Addr: "10.0.0.1:50000"},
+		ServiceId: "service",
+		KeyId:     "",
+		Signature: nil,
+	}
+
+	err := signer.VerifyDHTStore(storeMsg)
+	if err != errUnsignedMessage {
+		t.Fatalf("expected %s for an unsigned store message, got %v", errUnsignedMessage, err)
+	}
+
+	signedMsg, err := signer.SignDHTStore(storeMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = signer.VerifyDHTStore(signedMsg)
+	if err != nil {
+		t.Fatalf("valid store message signature rejected: %s", err)
+	}
+
+	signedMsg.Sender.Id = "node-1"
+	err = signer.VerifyDHTStore(signedMsg)
+	if err != errInvalidSignature {
+		t.Fatalf("expected %s for a tampered store message, got %v", errInvalidSignature, err)
+	}
+}
+
+// TestSignatureSurvivesEncoding checks that the signature still verifies after the message goes through
+// every payload encoding, since it is recomputed over the decoded message
+func TestSignatureSurvivesEncoding(t *testing.T) {
+	signer := newTestSigner(t, "a", "a")
+
+	signedMsg, err := signer.Sign(newTestDiscoverMsg())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encodingName := range []string{jsonEncodingName, cborEncodingName} {
+		for _, compressionName := range []string{noCompressionName, gzipCompressionName} {
+			encoding, err := newPayloadEncoding(encodingName, compressionName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encoded, err := encodePayload(encoding, signedMsg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decodedMsg := &api.DiscoverMsg{}
+			err = decodePayload(encoding.contentType, encoding.contentEncoding(), bytes.NewReader(encoded),
+				decodedMsg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = signer.Verify(decodedMsg)
+			if err != nil {
+				t.Errorf("signature does not verify after %s: %s", encoding, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package node
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRefreshInterval = 1 * time.Minute
+	defaultEntryTTL        = 3 * defaultRefreshInterval
+)
+
+// refreshLocalServices periodically re-advertises the local services up to their horizon, so the nodes that
+// know them do not let them expire
+func (n *Node) refreshLocalServices() {
+	ticker := time.NewTicker(n.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		discoverMsg := n.servicesTable.ToRefreshMsg(n.id)
+		if discoverMsg == nil {
+			continue
+		}
+
+		log.Debugf("refreshing %d local services", len(discoverMsg.Entries))
+
+		n.messagesReceived.Add(discoverMsg.MessageId)
+		n.broadcastMsgWithHorizon(discoverMsg)
+	}
+}
+
+// expireServices periodically deletes the remote services whose host stopped advertising them and withdraws
+// them from the neighbors
+func (n *Node) expireServices() {
+	if n.config.EntryTTL < 2*n.config.RefreshInterval {
+		log.Warnf("entry TTL %s is shorter than two refresh intervals (%s), a single lost refresh expires "+
+			"entries", n.config.EntryTTL, 2*n.config.RefreshInterval)
+	}
+
+	ticker := time.NewTicker(n.config.EntryTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		expired := n.servicesTable.ExpireServices(n.config.EntryTTL)
+		if len(expired) == 0 {
+			continue
+		}
+
+		log.Debugf("expired services %+v", expired)
+		n.sendWithdrawn(expired)
+	}
+}
@@ -0,0 +1,99 @@
+package node
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/bruno-anjos/archimedes/api"
+	"github.com/bruno-anjos/solution-utils/http_utils"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadTLSConfigFromEnv returns nil if no certificate is configured, in which case archimedes uses plain
+// HTTP
+func loadTLSConfigFromEnv() *tls.Config {
+	certFile, certOk := os.LookupEnv(tlsCertEnvVar)
+	keyFile, keyOk := os.LookupEnv(tlsKeyEnvVar)
+	caFile, caOk := os.LookupEnv(tlsCAEnvVar)
+
+	if !certOk && !keyOk && !caOk {
+		return nil
+	}
+
+	if !certOk || !keyOk || !caOk {
+		log.Fatalf("%s, %s and %s have to be set together", tlsCertEnvVar, tlsKeyEnvVar, tlsCAEnvVar)
+	}
+
+	tlsConfig, err := api.NewTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		log.Fatalf("could not load TLS config: %s", err)
+	}
+
+	return tlsConfig
+}
+
+// idFromTLSConfig returns the id the node certificate was issued to, which becomes the archimedes id
+func idFromTLSConfig(tlsConfig *tls.Config) (string, error) {
+	if len(tlsConfig.Certificates) == 0 {
+		return "", errors.New("TLS config has no certificate")
+	}
+
+	cert, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		return "", err
+	}
+
+	id := api.IdFromCertificate(cert)
+	if id == "" {
+		return "", errors.New("certificate has no common name to use as archimedes id")
+	}
+
+	return id, nil
+}
+
+// peerIdFromRequest returns the id in the client certificate of the request, if any
+func peerIdFromRequest(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+
+	return api.IdFromCertificate(r.TLS.PeerCertificates[0]), true
+}
+
+// isPeerAllowed checks that a node only speaks for itself, i.e. the id it claims is the one in its
+// certificate. Without TLS there is nothing to check against.
+func (n *Node) isPeerAllowed(r *http.Request, claimedId string) bool {
+	if n.config.TLSConfig == nil {
+		return true
+	}
+
+	peerId, ok := peerIdFromRequest(r)
+	if !ok || peerId != claimedId {
+		log.Warnf("%s claimed to be %s with certificate for %s", r.RemoteAddr, claimedId, peerId)
+		return false
+	}
+
+	return true
+}
+
+// newRouter routes the requests under the archimedes prefix path to the handlers
+func newRouter(routes []http_utils.Route) *mux.Router {
+	router := mux.NewRouter()
+	for _, route := range routes {
+		muxRoute := router.
+			Methods(route.Method).
+			Path(api.PrefixPath + route.Pattern).
+			Name(route.Name).
+			Handler(route.HandlerFunc)
+
+		if len(route.QueryParams) > 0 {
+			muxRoute.Queries(route.QueryParams...)
+		}
+	}
+
+	return router
+}
@@ -0,0 +1,30 @@
+package node
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTombstoneGracePeriod = 10 * time.Minute
+)
+
+// collectTombstones periodically deletes the tombstones that had enough time to reach every node
+func (n *Node) collectTombstones() {
+	ticker := time.NewTicker(n.config.TombstoneGracePeriod / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		collected := n.servicesTable.CollectTombstones(n.config.TombstoneGracePeriod)
+		if collected > 0 {
+			log.Debugf("collected %d tombstones", collected)
+		}
+	}
+}
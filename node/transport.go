@@ -0,0 +1,269 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bruno-anjos/archimedes/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	transportTimeout = 10 * time.Second
+)
+
+type (
+	// Transport is the lower level layer used to reach other archimedes nodes. It is an interface so it
+	// can be swapped (e.g. by an in-memory implementation in tests).
+	Transport interface {
+		// SendDiscoverMsg also reports whether the receiver already knew the message
+		SendDiscoverMsg(addr string, discoverMsg *api.DiscoverMsg) (known bool, err error)
+		WhoAreYou(addr string) (string, error)
+		SyncTable(addr string, digest *api.TableDigestDTO) (*api.DiscoverMsg, error)
+		// Query returns the answer of the receiver if it, or the nodes it forwards the query to, found the
+		// service. The answer is a discover message with the entry, so it is signed as any other. Queries and
+		// lookups are given up on when ctx is done, since a client is waiting on them.
+		Query(ctx context.Context, addr string, queryMsg *api.QueryMsg) (answer *api.DiscoverMsg, found bool,
+			err error)
+		DHTFind(ctx context.Context, addr string, findMsg *api.DHTFindMsg) (*api.DHTFindReplyDTO, error)
+		DHTStore(addr string, storeMsg *api.DHTStoreMsg) error
+	}
+
+	// httpTransport writes payloads with the preferred encoding until a neighbor answers that it does not
+	// support it, after which that neighbor gets plain JSON
+	httpTransport struct {
+		httpClient *http.Client
+		scheme     string
+		encoding   payloadEncoding
+		// neighborEncodings keeps, per neighbor address, the encoding the neighbor accepts
+		neighborEncodings sync.Map
+	}
+
+	typeNeighborEncodingsMapKey   = string
+	typeNeighborEncodingsMapValue = payloadEncoding
+)
+
+// newHTTPTransport uses HTTPS, presenting the node certificate, if tlsConfig is not nil
+func newHTTPTransport(tlsConfig *tls.Config, encoding payloadEncoding) *httpTransport {
+	if tlsConfig == nil {
+		return &httpTransport{
+			httpClient: &http.Client{
+				Timeout: transportTimeout,
+			},
+			scheme:            "http",
+			encoding:          encoding,
+			neighborEncodings: sync.Map{},
+		}
+	}
+
+	return &httpTransport{
+		httpClient: &http.Client{
+			Timeout: transportTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		scheme:            "https",
+		encoding:          encoding,
+		neighborEncodings: sync.Map{},
+	}
+}
+
+func (t *httpTransport) SendDiscoverMsg(addr string, discoverMsg *api.DiscoverMsg) (known bool, err error) {
+	status, err := t.doRequest(http.MethodPost, addr, api.GetDiscoverPath(), discoverMsg, nil)
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return false, nil
+	case http.StatusAlreadyReported:
+		return true, nil
+	default:
+		return false, errors.New(fmt.Sprintf("got status %d while sending discover message to %s", status,
+			addr))
+	}
+}
+
+func (t *httpTransport) WhoAreYou(addr string) (string, error) {
+	var id string
+	status, peerId, err := t.doRequestWithPeerId(context.Background(), http.MethodGet, addr,
+		api.GetWhoAreYouPath(), nil, &id)
+	if err != nil {
+		return "", err
+	}
+
+	if status != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("got status %d while asking %s who it is", status, addr))
+	}
+
+	// with TLS a node can only be who its certificate says it is
+	if t.scheme == "https" && peerId != id {
+		return "", errors.New(fmt.Sprintf("%s answered %s with certificate for %s", addr, id, peerId))
+	}
+
+	return id, nil
+}
+
+func (t *httpTransport) SyncTable(addr string, digest *api.TableDigestDTO) (*api.DiscoverMsg, error) {
+	discoverMsg := &api.DiscoverMsg{}
+	status, err := t.doRequest(http.MethodPost, addr, api.GetTablePath(), digest, discoverMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("got status %d while syncing table with %s", status, addr))
+	}
+
+	return discoverMsg, nil
+}
+
+func (t *httpTransport) Query(ctx context.Context, addr string, queryMsg *api.QueryMsg) (
+	answer *api.DiscoverMsg, found bool, err error) {
+	answer = &api.DiscoverMsg{}
+	status, err := t.doRequestWithContext(ctx, http.MethodPost, addr, api.GetQueryPath(), queryMsg, answer)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return answer, true, nil
+	case http.StatusNotFound, http.StatusAlreadyReported:
+		return nil, false, nil
+	default:
+		return nil, false, errors.New(fmt.Sprintf("got status %d while querying %s", status, addr))
+	}
+}
+
+func (t *httpTransport) DHTFind(ctx context.Context, addr string, findMsg *api.DHTFindMsg) (
+	*api.DHTFindReplyDTO, error) {
+	reply := &api.DHTFindReplyDTO{}
+	status, err := t.doRequestWithContext(ctx, http.MethodPost, addr, api.GetDHTFindPath(), findMsg, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("got status %d while looking up key in %s", status, addr))
+	}
+
+	return reply, nil
+}
+
+func (t *httpTransport) DHTStore(addr string, storeMsg *api.DHTStoreMsg) error {
+	status, err := t.doRequest(http.MethodPost, addr, api.GetDHTStorePath(), storeMsg, nil)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return errors.New(fmt.Sprintf("got status %d while storing service %s in %s", status,
+			storeMsg.ServiceId, addr))
+	}
+
+	return nil
+}
+
+// doRequest is used instead of http_utils.DoRequest since a neighbor being unreachable is an expected
+// condition and has to be reported as an error instead of crashing the node.
+func (t *httpTransport) doRequest(method, addr, path string, body, responseBody interface{}) (int, error) {
+	return t.doRequestWithContext(context.Background(), method, addr, path, body, responseBody)
+}
+
+// doRequestWithContext gives up on the request when ctx is done
+func (t *httpTransport) doRequestWithContext(ctx context.Context, method, addr, path string, body,
+	responseBody interface{}) (int, error) {
+	status, _, err := t.doRequestWithPeerId(ctx, method, addr, path, body, responseBody)
+	return status, err
+}
+
+// doRequestWithPeerId also returns the id in the certificate the server presented, if using TLS
+func (t *httpTransport) doRequestWithPeerId(ctx context.Context, method, addr, path string, body,
+	responseBody interface{}) (status int, peerId string, err error) {
+	encoding := t.encodingFor(addr)
+
+	status, peerId, err = t.doRequestWithEncoding(ctx, method, addr, path, body, responseBody, encoding)
+	if err != nil || status != http.StatusUnsupportedMediaType || encoding == jsonPayloadEncoding {
+		return status, peerId, err
+	}
+
+	log.Warnf("%s does not support %s, falling back to %s", addr, encoding, jsonPayloadEncoding)
+	t.neighborEncodings.Store(addr, jsonPayloadEncoding)
+
+	return t.doRequestWithEncoding(ctx, method, addr, path, body, responseBody, jsonPayloadEncoding)
+}
+
+func (t *httpTransport) encodingFor(addr string) payloadEncoding {
+	value, ok := t.neighborEncodings.Load(addr)
+	if !ok {
+		return t.encoding
+	}
+
+	return value.(typeNeighborEncodingsMapValue)
+}
+
+func (t *httpTransport) doRequestWithEncoding(ctx context.Context, method, addr, path string, body,
+	responseBody interface{}, encoding payloadEncoding) (status int, peerId string, err error) {
+	hostUrl := url.URL{
+		Scheme: t.scheme,
+		Host:   addr,
+		Path:   path,
+	}
+
+	var bodyReader *bytes.Reader
+	if body != nil {
+		encoded, err := encodePayload(encoding, body)
+		if err != nil {
+			return 0, "", err
+		}
+		bodyReader = bytes.NewReader(encoded)
+	} else {
+		bodyReader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, hostUrl.String(), bodyReader)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", encoding.contentType)
+	if encoding.gzip {
+		req.Header.Set("Content-Encoding", encoding.contentEncoding())
+	}
+	// JSON is always accepted so replies from nodes without the compact encoding can still be read
+	req.Header.Set("Accept", fmt.Sprintf("%s, %s", encoding.contentType, jsonContentType))
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Error(closeErr)
+		}
+	}()
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		peerId = api.IdFromCertificate(resp.TLS.PeerCertificates[0])
+	}
+
+	if responseBody != nil && resp.StatusCode == http.StatusOK {
+		// the http client already took care of decompressing gzip replies
+		err = decodePayload(replyContentType(resp.Header.Get("Content-Type")), "", resp.Body, responseBody)
+		if err != nil {
+			return resp.StatusCode, peerId, err
+		}
+	}
+
+	return resp.StatusCode, peerId, nil
+}